@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base32"
+	"strings"
+)
+
+// Site address is a compact, case-insensitive and checksummed text form of public key
+// that can be used as a host name in URLs.
+//
+// Address is lower-case base32 (RFC 4648, without padding) of [type tag][public key][4-byte checksum],
+// where checksum is the first bytes of SHA-256("dweb-address:" | type tag | public key).
+// Address of Ed25519 key is 60 characters long, so it fits in a DNS label (63 characters).
+// Multi-keys are too long for a DNS label and have no address.
+const (
+	addressTagEd25519   = 0x01
+	addressChecksumSize = 4
+)
+
+var addressEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Address returns site address of Ed25519 public key ("" for other keys)
+func (pub PublicKey) Address() string {
+	if len(pub) != PublicKeySize {
+		return ""
+	}
+	buf := append([]byte{addressTagEd25519}, pub...)
+	buf = append(buf, addressChecksum(buf)...)
+	return strings.ToLower(addressEncoding.EncodeToString(buf))
+}
+
+// DecodeAddress returns public key by site address or nil if the address is invalid
+func DecodeAddress(s string) PublicKey {
+	buf, err := addressEncoding.DecodeString(strings.ToUpper(s))
+	if err != nil || len(buf) < 1+addressChecksumSize {
+		return nil
+	}
+	n := len(buf) - addressChecksumSize
+	if !bytes.Equal(buf[n:], addressChecksum(buf[:n])) {
+		return nil
+	}
+	if pub := PublicKey(buf[1:n]); buf[0] == addressTagEd25519 && len(pub) == PublicKeySize {
+		return pub
+	}
+	return nil
+}
+
+func addressChecksum(data []byte) []byte {
+	return SHA256.Hash([]byte("dweb-address:"), data)[:addressChecksumSize]
+}
@@ -0,0 +1,43 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPublicKey_Address(t *testing.T) {
+	pub := NewPrivateKeyFromSeed("seed").PublicKey()
+
+	addr := pub.Address()
+
+	assert(t, len(addr) == 60)
+	assert(t, addr == strings.ToLower(addr))
+	assert(t, DecodeAddress(addr).Equal(pub))
+	assert(t, DecodeAddress(strings.ToUpper(addr)).Equal(pub))
+	assert(t, DecodePublicKey(pub.Encode()).Address() == addr)
+}
+
+func TestPublicKey_Address_multi(t *testing.T) {
+	pub, _ := testMultiKey(2)
+
+	// multi-key is too long for a DNS label
+	assert(t, pub.Address() == "")
+	buf := append([]byte{0x02}, pub...)
+	buf = append(buf, addressChecksum(buf)...)
+	assert(t, DecodeAddress(addressEncoding.EncodeToString(buf)) == nil)
+}
+
+func TestDecodeAddress_fail(t *testing.T) {
+	addr := NewPrivateKeyFromSeed("seed").PublicKey().Address()
+
+	assert(t, DecodeAddress("") == nil)
+	assert(t, DecodeAddress(addr[:59]) == nil)
+	assert(t, DecodeAddress(addr+"a") == nil)
+	typo := "a"
+	if addr[10] == 'a' {
+		typo = "b"
+	}
+	assert(t, DecodeAddress(addr[:10]+typo+addr[11:]) == nil)
+	assert(t, DecodeAddress(addr[:10]+"0"+addr[11:]) == nil) // not base32 char
+	assert(t, PublicKey("short").Address() == "")
+}
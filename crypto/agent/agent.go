@@ -0,0 +1,213 @@
+// Package agent implements a local signing agent that keeps private keys out of the process memory.
+//
+// The agent listens on a unix socket. Every connection carries one JSON request and one JSON response:
+//
+//	{"Method":"keys"}                                      -> {"Keys":["Ed25519,..."]}
+//	{"Method":"sign","PublicKey":"Ed25519,...","Digest":".."} -> {"Signature":".."}
+//
+// Failed requests are answered by {"Error":"..."}.
+//
+// The agent signs any digest by request of any client connected to the socket,
+// so access to the socket is access to the keys. ListenAndServe makes the socket
+// accessible only by the user of the agent process; the socket of the listener passed to Serve
+// must be protected by the caller.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	methodKeys = "keys"
+	methodSign = "sign"
+
+	maxMessageSize = 64 << 10
+	maxDigestSize  = 64
+	requestTimeout = 30 * time.Second
+	socketMode     = 0600
+)
+
+var (
+	ErrKeyNotFound    = errors.New("agent: key not found")
+	ErrInvalidRequest = errors.New("agent: invalid request")
+)
+
+type request struct {
+	Method    string `json:",omitempty"`
+	PublicKey string `json:",omitempty"`
+	Digest    []byte `json:",omitempty"`
+}
+
+type response struct {
+	Keys      []string `json:",omitempty"`
+	Signature []byte   `json:",omitempty"`
+	Error     string   `json:",omitempty"`
+}
+
+// Agent holds private keys and signs digests by requests of clients
+type Agent struct {
+	mx   sync.RWMutex
+	keys map[string]crypto.PrivateKey
+}
+
+func New(keys ...crypto.PrivateKey) *Agent {
+	a := &Agent{keys: map[string]crypto.PrivateKey{}}
+	for _, prv := range keys {
+		a.Add(prv)
+	}
+	return a
+}
+
+// Add adds private key to the agent
+func (a *Agent) Add(prv crypto.PrivateKey) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.keys[prv.PublicKey().Encode()] = prv
+}
+
+// ListenAndServe listens on the unix socket and serves requests of clients.
+// The socket is accessible only by the owner (mode 0600).
+func (a *Agent) ListenAndServe(socketPath string) error {
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(socketPath, socketMode); err != nil {
+		l.Close()
+		return err
+	}
+	return a.Serve(l)
+}
+
+// Serve serves requests of clients accepted by the listener until the listener is closed
+func (a *Agent) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req request
+	var resp response
+	if err := json.NewDecoder(io.LimitReader(conn, maxMessageSize)).Decode(&req); err != nil {
+		resp.Error = ErrInvalidRequest.Error()
+	} else {
+		resp = a.handle(req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (a *Agent) handle(req request) (resp response) {
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+
+	switch req.Method {
+	case methodKeys:
+		resp.Keys = []string{}
+		for k := range a.keys {
+			resp.Keys = append(resp.Keys, k)
+		}
+	case methodSign:
+		prv := a.keys[req.PublicKey]
+		if prv == nil {
+			resp.Error = ErrKeyNotFound.Error()
+		} else if len(req.Digest) == 0 || len(req.Digest) > maxDigestSize {
+			resp.Error = ErrInvalidRequest.Error()
+		} else {
+			resp.Signature = prv.Sign(req.Digest)
+		}
+	default:
+		resp.Error = ErrInvalidRequest.Error()
+	}
+	return
+}
+
+// Client is a client of the agent listening on the unix socket
+type Client struct {
+	socketPath string
+}
+
+func NewClient(socketPath string) *Client {
+	return &Client{socketPath}
+}
+
+func (c *Client) call(req request) (resp response, err error) {
+	conn, err := net.DialTimeout("unix", c.socketPath, requestTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return
+	}
+	if err = json.NewDecoder(io.LimitReader(conn, maxMessageSize)).Decode(&resp); err != nil {
+		return
+	}
+	if resp.Error != "" {
+		switch resp.Error {
+		case ErrKeyNotFound.Error():
+			err = ErrKeyNotFound
+		case ErrInvalidRequest.Error():
+			err = ErrInvalidRequest
+		default:
+			err = errors.New(resp.Error)
+		}
+	}
+	return
+}
+
+// Keys returns public keys of the agent
+func (c *Client) Keys() (keys []crypto.PublicKey, err error) {
+	resp, err := c.call(request{Method: methodKeys})
+	for _, s := range resp.Keys {
+		if pub := crypto.DecodePublicKey(s); pub != nil {
+			keys = append(keys, pub)
+		}
+	}
+	return
+}
+
+// Signer returns crypto.Signer that signs digests by the agent key pub
+func (c *Client) Signer(pub crypto.PublicKey) crypto.Signer {
+	return &signer{c, pub}
+}
+
+type signer struct {
+	c   *Client
+	pub crypto.PublicKey
+}
+
+func (s *signer) PublicKey() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *signer) SignDigest(digest []byte) ([]byte, error) {
+	resp, err := s.c.call(request{Method: methodSign, PublicKey: s.pub.Encode(), Digest: digest})
+	if err != nil {
+		return nil, err
+	}
+	if !s.pub.Verify(digest, resp.Signature) {
+		return nil, errors.New("agent: invalid signature")
+	}
+	return resp.Signature, nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"github.com/denisskin/dweb/crypto"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func assert(t *testing.T, ok bool) {
+	if !ok {
+		t.Helper()
+		t.Fatal()
+	}
+}
+
+func startTestAgent(t *testing.T, keys ...crypto.PrivateKey) *Client {
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socketPath)
+	assert(t, err == nil)
+	t.Cleanup(func() { l.Close() })
+	go New(keys...).Serve(l)
+	return NewClient(socketPath)
+}
+
+func TestAgent(t *testing.T) {
+	prv := crypto.NewPrivateKeyFromSeed("agent-key")
+	c := startTestAgent(t, prv)
+
+	keys, err := c.Keys()
+	assert(t, err == nil)
+	assert(t, len(keys) == 1)
+	assert(t, keys[0].Equal(prv.PublicKey()))
+
+	digest := crypto.Hash([]byte("data"))
+	var s crypto.Signer = c.Signer(prv.PublicKey())
+	sig, err := s.SignDigest(digest)
+	assert(t, err == nil)
+	assert(t, prv.PublicKey().Verify(digest, sig))
+
+	// unknown key
+	_, err = c.Signer(crypto.NewPrivateKeyFromSeed("other").PublicKey()).SignDigest(digest)
+	assert(t, err == ErrKeyNotFound)
+
+	// invalid digest
+	_, err = s.SignDigest(nil)
+	assert(t, err == ErrInvalidRequest)
+}
+
+func TestAgent_ListenAndServe(t *testing.T) {
+	prv := crypto.NewPrivateKeyFromSeed("agent-key")
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	go New(prv).ListenAndServe(socketPath)
+
+	c := NewClient(socketPath)
+	var keys []crypto.PublicKey
+	var err error
+	for i := 0; i < 100; i++ { // wait for the agent
+		if keys, err = c.Keys(); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert(t, err == nil)
+	assert(t, len(keys) == 1)
+
+	// the socket is accessible only by the owner
+	fi, err := os.Stat(socketPath)
+	assert(t, err == nil)
+	assert(t, fi.Mode().Perm() == 0600)
+}
+
+func TestClient_noAgent(t *testing.T) {
+	c := NewClient(filepath.Join(t.TempDir(), "none.sock"))
+
+	_, err := c.Keys()
+	assert(t, err != nil)
+}
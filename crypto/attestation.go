@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// Attestation is a countersignature of a notary that the hash existed at the time.
+//
+// The notary signs the digest SHA-256("dweb-attestation:" | hash | 8-byte big-endian unix time).
+type Attestation struct {
+	Notary    PublicKey // public key of the notary
+	Hash      []byte    // attested hash (hash of root-header of the site)
+	Time      time.Time // time of the attestation (in seconds)
+	Signature []byte    // signature of the notary
+}
+
+// NewAttestation makes attestation of the hash signed by the notary signer
+func NewAttestation(notary Signer, hash []byte, t time.Time) (a *Attestation, err error) {
+	a = &Attestation{
+		Notary: notary.PublicKey(),
+		Hash:   hash,
+		Time:   time.Unix(t.Unix(), 0).UTC(),
+	}
+	if a.Signature, err = notary.SignDigest(a.digest()); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (a *Attestation) digest() []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(a.Time.Unix()))
+	return SHA256.Hash([]byte("dweb-attestation:"), a.Hash, ts[:])
+}
+
+// Verify verifies that the attestation of the hash is signed by its notary
+func (a *Attestation) Verify(hash []byte) bool {
+	return a != nil &&
+		len(hash) > 0 &&
+		bytes.Equal(a.Hash, hash) &&
+		a.Time.Unix() > 0 &&
+		a.Notary.Verify(a.digest(), a.Signature)
+}
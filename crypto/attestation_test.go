@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewAttestation(t *testing.T) {
+	notary := NewPrivateKeyFromSeed("notary")
+	hash := Hash([]byte("root-header"))
+	ts := time.Date(2022, 1, 1, 12, 0, 0, 500, time.UTC)
+
+	a, err := NewAttestation(notary, hash, ts)
+	assert(t, err == nil)
+	assert(t, a.Notary.Equal(notary.PublicKey()))
+	assert(t, a.Time.Equal(ts.Truncate(time.Second)))
+	assert(t, a.Verify(hash))
+
+	// portable
+	var a1 *Attestation
+	data, _ := json.Marshal(a)
+	assert(t, json.Unmarshal(data, &a1) == nil)
+	assert(t, a1.Verify(hash))
+
+	// another hash
+	assert(t, !a.Verify(Hash([]byte("other"))))
+
+	// modified time
+	a1.Time = a1.Time.Add(-time.Hour)
+	assert(t, !a1.Verify(hash))
+
+	// another notary
+	a1, _ = NewAttestation(notary, hash, ts)
+	a1.Notary = NewPrivateKeyFromSeed("other").PublicKey()
+	assert(t, !a1.Verify(hash))
+}
@@ -0,0 +1,167 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"filippo.io/edwards25519"
+)
+
+// batchMinSize is the min count of signatures that are verified by batch equation.
+// Smaller batches are verified one by one.
+const batchMinSize = 4
+
+// BatchVerifier verifies many signatures at once.
+//
+// Signatures are checked by the randomized batch equation
+//
+//	[8](-(∑ z_i s_i) B + ∑ z_i R_i + ∑ (z_i k_i) A_i) == 0
+//
+// that is faster than verification of signatures one by one.
+// If the batch equation fails, signatures are checked one by one by ed25519.Verify (as PublicKey.Verify does),
+// VerifyEach splits the failed batch to find invalid signatures. Small batches are checked one by one.
+//
+// Every signature that is valid for PublicKey.Verify is valid for BatchVerifier.
+// The batch equation is cofactored, so BatchVerifier can also accept a signature
+// with small-order components, that can be made by the owner of the key only.
+type BatchVerifier struct {
+	items []batchItem
+	sigs  []batchSig
+}
+
+type batchItem struct {
+	ok          bool // item is well-formed
+	first, last int  // range of item signatures in BatchVerifier.sigs
+}
+
+type batchSig struct {
+	pub, message, sig []byte
+}
+
+func NewBatchVerifier() *BatchVerifier {
+	return &BatchVerifier{}
+}
+
+// Add adds signature of the message to the batch.
+// Multi-signature is split to signatures of signers.
+func (v *BatchVerifier) Add(pub PublicKey, message, signature []byte) {
+	it := batchItem{first: len(v.sigs)}
+	if pub.IsMulti() {
+		ss, err := pub.splitMultiSignature(signature)
+		it.ok = err == nil && len(ss) >= pub.Threshold()
+		if it.ok {
+			keys := pub.Keys()
+			for _, s := range ss {
+				v.sigs = append(v.sigs, batchSig{keys[s[0]], message, s[1:]})
+			}
+		}
+	} else {
+		it.ok = len(pub) == PublicKeySize && len(signature) == SignatureSize
+		if it.ok {
+			v.sigs = append(v.sigs, batchSig{pub, message, signature})
+		}
+	}
+	it.last = len(v.sigs)
+	v.items = append(v.items, it)
+}
+
+// Len returns count of added signatures
+func (v *BatchVerifier) Len() int {
+	return len(v.items)
+}
+
+// Verify says that all added signatures are valid
+func (v *BatchVerifier) Verify() bool {
+	for _, it := range v.items {
+		if !it.ok {
+			return false
+		}
+	}
+	if len(v.sigs) >= batchMinSize && verifyBatch(v.sigs) {
+		return true
+	}
+	return verifyEach(v.sigs, make([]bool, len(v.sigs)))
+}
+
+// VerifyEach returns validity of every added signature in order of adding
+func (v *BatchVerifier) VerifyEach() []bool {
+	valid := make([]bool, len(v.sigs))
+	verifyBisect(v.sigs, valid)
+	res := make([]bool, len(v.items))
+	for i, it := range v.items {
+		res[i] = it.ok
+		for j := it.first; j < it.last; j++ {
+			res[i] = res[i] && valid[j]
+		}
+	}
+	return res
+}
+
+// verifyBisect verifies signatures by batches and splits failed batches in halves
+func verifyBisect(ss []batchSig, valid []bool) {
+	if len(ss) < batchMinSize {
+		verifyEach(ss, valid)
+		return
+	}
+	if verifyBatch(ss) {
+		for i := range valid {
+			valid[i] = true
+		}
+		return
+	}
+	i := len(ss) / 2
+	verifyBisect(ss[:i], valid[:i])
+	verifyBisect(ss[i:], valid[i:])
+}
+
+func verifyEach(ss []batchSig, valid []bool) bool {
+	ok := true
+	for i, s := range ss {
+		valid[i] = ed25519.Verify(s.pub, s.message, s.sig)
+		ok = ok && valid[i]
+	}
+	return ok
+}
+
+// verifyBatch checks the batch equation for signatures
+func verifyBatch(ss []batchSig) bool {
+	n := len(ss)
+	scalars := make([]*edwards25519.Scalar, 0, 1+2*n)
+	points := make([]*edwards25519.Point, 0, 1+2*n)
+
+	bScalar := edwards25519.NewScalar() // ∑ z_i s_i
+	scalars, points = append(scalars, bScalar), append(points, edwards25519.NewGeneratorPoint())
+
+	rnd := randBytes(16 * n)
+	for i, s := range ss {
+		A, err := new(edwards25519.Point).SetBytes(s.pub)
+		if err != nil {
+			return false
+		}
+		R, err := new(edwards25519.Point).SetBytes(s.sig[:32])
+		if err != nil || string(R.Bytes()) != string(s.sig[:32]) { // non-canonical R is not valid for ed25519.Verify
+			return false
+		}
+		S, err := edwards25519.NewScalar().SetCanonicalBytes(s.sig[32:])
+		if err != nil {
+			return false
+		}
+		h := sha512.New()
+		h.Write(s.sig[:32])
+		h.Write(s.pub)
+		h.Write(s.message)
+		k, _ := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+
+		// random 128-bit z
+		var zb [32]byte
+		copy(zb[:16], rnd[16*i:])
+		z, _ := edwards25519.NewScalar().SetCanonicalBytes(zb[:])
+
+		bScalar.MultiplyAdd(z, S, bScalar)
+		scalars = append(scalars, z, edwards25519.NewScalar().Multiply(z, k))
+		points = append(points, R, A)
+	}
+	bScalar.Negate(bScalar)
+
+	p := new(edwards25519.Point).VarTimeMultiScalarMult(scalars, points)
+	return p.MultByCofactor(p).Equal(edwards25519.NewIdentityPoint()) == 1
+}
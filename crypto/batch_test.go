@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"filippo.io/edwards25519"
+	"fmt"
+	"testing"
+)
+
+func testBatch(n int) (*BatchVerifier, [][3][]byte) {
+	v := NewBatchVerifier()
+	var tt [][3][]byte
+	for i := 0; i < n; i++ {
+		prv := NewPrivateKeyFromSeed(fmt.Sprint("key-", i))
+		msg := []byte(fmt.Sprint("message-", i))
+		sig := prv.Sign(msg)
+		v.Add(prv.PublicKey(), msg, sig)
+		tt = append(tt, [3][]byte{prv.PublicKey(), msg, sig})
+	}
+	return v, tt
+}
+
+func TestBatchVerifier(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 4, 10, 65} {
+		v, _ := testBatch(n)
+		assert(t, v.Len() == n)
+		assert(t, v.Verify())
+		for _, ok := range v.VerifyEach() {
+			assert(t, ok)
+		}
+	}
+}
+
+func TestBatchVerifier_fail(t *testing.T) {
+	for _, n := range []int{1, 3, 4, 10, 65} {
+		_, tt := testBatch(n)
+		invalid := map[int]bool{n / 2: true, n - 1: true}
+
+		v := NewBatchVerifier()
+		for i, e := range tt {
+			if invalid[i] {
+				e[1] = []byte("another message")
+			}
+			v.Add(e[0], e[1], e[2])
+		}
+		assert(t, !v.Verify())
+		for i, ok := range v.VerifyEach() {
+			assert(t, ok == !invalid[i])
+		}
+	}
+}
+
+func TestBatchVerifier_malformed(t *testing.T) {
+	v, tt := testBatch(8)
+	sig := append([]byte{}, tt[0][2]...)
+	sig[63] |= 0xf0 // non-canonical S
+	v.Add(tt[0][0], tt[0][1], sig)
+	v.Add(tt[1][0][:31], tt[1][1], tt[1][2]) // invalid key
+	v.Add(tt[2][0], tt[2][1], tt[2][2][:63]) // invalid signature size
+	v.Add(tt[3][0], tt[3][1], tt[3][2])
+
+	assert(t, !v.Verify())
+	assert(t, fmt.Sprint(v.VerifyEach()) == "[true true true true true true true true false false false true]")
+}
+
+func TestBatchVerifier_multiSignature(t *testing.T) {
+	pub, prvs := testMultiKey(2)
+	msg := []byte("message")
+	sig1, _ := prvs[0].MultiSign(pub, msg)
+	sig2, _ := prvs[1].MultiSign(pub, msg)
+	sig, _ := MergeMultiSignatures(pub, msg, sig1, sig2)
+
+	v, _ := testBatch(4)
+	v.Add(pub, msg, sig)
+	v.Add(pub, msg, sig1) // 1 of 2
+	v.Add(pub, []byte("other"), sig)
+
+	assert(t, !v.Verify())
+	assert(t, fmt.Sprint(v.VerifyEach()) == "[true true true true true false false]")
+}
+
+// signWithTorsion makes signature with small-order component T of R: S B - R - k A == -T
+func signWithTorsion(prv PrivateKey, msg []byte) []byte {
+	T, _ := new(edwards25519.Point).SetBytes(append([]byte{0xec}, append(bytes.Repeat([]byte{0xff}, 30), 0x7f)...)) // (0,-1) of order 2
+	h := sha512.Sum512(prv[:32])
+	a, _ := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	r, _ := edwards25519.NewScalar().SetUniformBytes(bytes.Repeat([]byte{1}, 64))
+	R := new(edwards25519.Point).ScalarBaseMult(r)
+	R.Add(R, T)
+	kh := sha512.New()
+	kh.Write(R.Bytes())
+	kh.Write(prv.PublicKey())
+	kh.Write(msg)
+	k, _ := edwards25519.NewScalar().SetUniformBytes(kh.Sum(nil))
+	S := edwards25519.NewScalar().MultiplyAdd(k, a, r)
+	return append(R.Bytes(), S.Bytes()...)
+}
+
+func TestBatchVerifier_smallOrderComponent(t *testing.T) {
+	prv := NewPrivateKeyFromSeed("key-torsion")
+	msg := []byte("message")
+	sig := signWithTorsion(prv, msg)
+
+	// single signatures and small batches are checked by ed25519.Verify
+	assert(t, !prv.PublicKey().Verify(msg, sig))
+	for _, n := range []int{0, 2} {
+		v, _ := testBatch(n)
+		v.Add(prv.PublicKey(), msg, sig)
+		assert(t, !v.Verify())
+		assert(t, fmt.Sprint(v.VerifyEach()[n:]) == "[false]")
+	}
+
+	// the cofactored batch equation accepts the signature
+	v, _ := testBatch(10)
+	v.Add(prv.PublicKey(), msg, sig)
+	assert(t, v.Verify())
+}
+
+func BenchmarkBatchVerifier_Verify(b *testing.B) {
+	v, _ := testBatch(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Verify()
+	}
+}
+
+func BenchmarkPublicKey_Verify_64(b *testing.B) {
+	_, tt := testBatch(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range tt {
+			PublicKey(e[0]).Verify(e[1], e[2])
+		}
+	}
+}
@@ -2,22 +2,67 @@ package crypto
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
+	"github.com/zeebo/blake3"
 	"hash"
 )
 
-// HashSize is the size of a hash-checksum in bytes.
+// HashSize is the size of a hash-checksum of the default hash algorithm in bytes.
 const HashSize = 32
 
-// NewHash returns a new hash.Hash computing the SHA256 checksum.
-func NewHash() hash.Hash {
-	return sha256.New()
+// HashAlgorithm identifies a hash function
+type HashAlgorithm string
+
+const (
+	SHA256     HashAlgorithm = "SHA-256"
+	SHA512_256 HashAlgorithm = "SHA-512/256"
+	BLAKE3     HashAlgorithm = "BLAKE3"
+
+	DefaultHashAlgorithm = SHA256
+)
+
+// IsSupported says the hash algorithm is supported
+func (a HashAlgorithm) IsSupported() bool {
+	switch a {
+	case SHA256, SHA512_256, BLAKE3:
+		return true
+	}
+	return false
 }
 
-// Hash returns the SHA256 checksum of concatenated arguments.
-func Hash(vv ...[]byte) []byte {
-	h := NewHash()
+// Size returns the size of a hash-checksum in bytes.
+func (a HashAlgorithm) Size() int {
+	return HashSize // all supported algorithms produce 256-bit checksums
+}
+
+// New returns a new hash.Hash computing the checksum.
+func (a HashAlgorithm) New() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New()
+	case SHA512_256:
+		return sha512.New512_256()
+	case BLAKE3:
+		return blake3.New()
+	}
+	panic("unsupported hash algorithm " + string(a))
+}
+
+// Hash returns the checksum of concatenated arguments.
+func (a HashAlgorithm) Hash(vv ...[]byte) []byte {
+	h := a.New()
 	for _, v := range vv {
 		h.Write(v)
 	}
 	return h.Sum(nil)
 }
+
+// NewHash returns a new hash.Hash computing the SHA256 checksum.
+func NewHash() hash.Hash {
+	return DefaultHashAlgorithm.New()
+}
+
+// Hash returns the SHA256 checksum of concatenated arguments.
+func Hash(vv ...[]byte) []byte {
+	return DefaultHashAlgorithm.Hash(vv...)
+}
@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashAlgorithm_Hash(t *testing.T) {
+	assert(t, hex.EncodeToString(SHA256.Hash()) == "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	assert(t, hex.EncodeToString(SHA512_256.Hash()) == "c672b8d1ef56ed28ab87c3622c5114069bdd3ad7b8f9737498d0c01ecef0967a")
+	assert(t, hex.EncodeToString(BLAKE3.Hash()) == "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262")
+
+	for _, alg := range []HashAlgorithm{SHA256, SHA512_256, BLAKE3} {
+		assert(t, alg.IsSupported())
+		assert(t, len(alg.Hash([]byte("abc"))) == alg.Size())
+	}
+	assert(t, !HashAlgorithm("MD5").IsSupported())
+}
+
+func TestHashAlgorithm_MerkleWitness(t *testing.T) {
+	for _, alg := range []HashAlgorithm{SHA256, SHA512_256, BLAKE3} {
+		hashes := make([][]byte, 7)
+		for i := range hashes {
+			hashes[i] = alg.Hash([]byte{byte(i)})
+		}
+		root := alg.MerkleRoot(hashes...)
+		for i := range hashes {
+			witness := alg.MakeMerkleWitness(hashes, i)[alg.Size():]
+			assert(t, alg.VerifyMerkleWitnessAt(hashes[i], root, witness, i, len(hashes)))
+		}
+		assert(t, !BLAKE3.VerifyMerkleWitness(hashes[0], SHA256.MerkleRoot(hashes...), SHA256.MakeMerkleWitness(hashes, 0)[HashSize:]))
+	}
+}
@@ -0,0 +1,248 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeyStore is a directory of passphrase-encrypted private keys.
+// Every key is stored in a separate file <name>.key
+type KeyStore struct {
+	dir string
+}
+
+type KeyInfo struct {
+	Name      string
+	PublicKey PublicKey
+}
+
+const (
+	keyStoreFileExt = ".key"
+	keyStoreVersion = 1
+	keyStoreKDF     = "argon2id"
+	keyStoreCipher  = "aes-256-gcm"
+
+	// argon2id params (RFC 9106, second recommended option)
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // 64 MiB
+	argon2Threads = 4
+	argon2SaltLen = 16
+)
+
+var (
+	ErrKeyNotFound          = errors.New("keystore: key not found")
+	ErrKeyExists            = errors.New("keystore: key already exists")
+	ErrInvalidKeyName       = errors.New("keystore: invalid key name")
+	ErrInvalidPassphrase    = errors.New("keystore: invalid passphrase")
+	ErrInvalidKeyStoreEntry = errors.New("keystore: invalid key file")
+)
+
+// encryptedKey is a format of key file
+type encryptedKey struct {
+	Version    int       `json:"version"`
+	PublicKey  string    `json:"public_key"`
+	KDF        string    `json:"kdf"`
+	KDFParams  kdfParams `json:"kdf_params"`
+	Cipher     string    `json:"cipher"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"` // encrypted private key seed
+}
+
+type kdfParams struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Salt    []byte `json:"salt"`
+}
+
+// OpenKeyStore opens or creates key store in the directory
+func OpenKeyStore(dir string) (*KeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &KeyStore{dir}, nil
+}
+
+// Save encrypts private key by passphrase and saves it to the key store.
+// It never overwrites the existing key file.
+func (ks *KeyStore) Save(name string, prv PrivateKey, passphrase string) error {
+	if !isValidKeyName(name) {
+		return ErrInvalidKeyName
+	}
+	path := ks.path(name)
+	if _, err := os.Lstat(path); err == nil { // fail fast before the expensive key derivation
+		return ErrKeyExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	data, err := EncryptPrivateKey(prv, passphrase)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(ks.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	// link the complete file to the key path; unlike rename, it fails if the key file was created meanwhile
+	if err = os.Link(tmp.Name(), path); os.IsExist(err) {
+		return ErrKeyExists
+	}
+	return err
+}
+
+// Load decrypts private key from the key store
+func (ks *KeyStore) Load(name, passphrase string) (PrivateKey, error) {
+	if !isValidKeyName(name) {
+		return nil, ErrInvalidKeyName
+	}
+	data, err := os.ReadFile(ks.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return DecryptPrivateKey(data, passphrase)
+}
+
+// List returns names and public keys of stored keys. It does not require passphrases.
+func (ks *KeyStore) List() (keys []KeyInfo, err error) {
+	files, err := filepath.Glob(filepath.Join(ks.dir, "*"+keyStoreFileExt))
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+	for _, path := range files {
+		name := strings.TrimSuffix(filepath.Base(path), keyStoreFileExt)
+		if !isValidKeyName(name) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var v encryptedKey
+		if err = json.Unmarshal(data, &v); err != nil {
+			return nil, ErrInvalidKeyStoreEntry
+		}
+		keys = append(keys, KeyInfo{name, DecodePublicKey(v.PublicKey)})
+	}
+	return
+}
+
+func (ks *KeyStore) path(name string) string {
+	return filepath.Join(ks.dir, name+keyStoreFileExt)
+}
+
+func isValidKeyName(name string) bool {
+	if name == "" || name[0] == '.' || len(name) > 200 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '@') {
+			return false
+		}
+	}
+	return true
+}
+
+// EncryptPrivateKey encrypts private key by passphrase (argon2id + AES-256-GCM)
+func EncryptPrivateKey(prv PrivateKey, passphrase string) ([]byte, error) {
+	if len(prv) != ed25519.PrivateKeySize {
+		return nil, errors.New("keystore: invalid private key")
+	}
+	v := encryptedKey{
+		Version:   keyStoreVersion,
+		PublicKey: prv.PublicKey().Encode(),
+		KDF:       keyStoreKDF,
+		KDFParams: kdfParams{
+			Time:    argon2Time,
+			Memory:  argon2Memory,
+			Threads: argon2Threads,
+			Salt:    randBytes(argon2SaltLen),
+		},
+		Cipher: keyStoreCipher,
+	}
+	aead, err := v.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	v.Nonce = randBytes(aead.NonceSize())
+	v.Ciphertext = aead.Seal(nil, v.Nonce, prv[:ed25519.SeedSize], v.additionalData())
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// DecryptPrivateKey decrypts private key encrypted by EncryptPrivateKey
+func DecryptPrivateKey(data []byte, passphrase string) (PrivateKey, error) {
+	var v encryptedKey
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, ErrInvalidKeyStoreEntry
+	}
+	if v.Version != keyStoreVersion || v.KDF != keyStoreKDF || v.Cipher != keyStoreCipher {
+		return nil, ErrInvalidKeyStoreEntry
+	}
+	aead, err := v.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.Nonce) != aead.NonceSize() {
+		return nil, ErrInvalidKeyStoreEntry
+	}
+	seed, err := aead.Open(nil, v.Nonce, v.Ciphertext, v.additionalData())
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidKeyStoreEntry
+	}
+	prv := PrivateKey(ed25519.NewKeyFromSeed(seed))
+	if prv.PublicKey().Encode() != v.PublicKey {
+		return nil, ErrInvalidKeyStoreEntry
+	}
+	return prv, nil
+}
+
+func (v *encryptedKey) aead(passphrase string) (cipher.AEAD, error) {
+	p := v.KDFParams
+	if p.Time == 0 || p.Time > 64 || p.Memory == 0 || p.Memory > 1<<20 || p.Threads == 0 || len(p.Salt) < argon2SaltLen {
+		return nil, ErrInvalidKeyStoreEntry
+	}
+	key := argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the key file metadata to the ciphertext
+func (v *encryptedKey) additionalData() []byte {
+	b, _ := json.Marshal([]any{v.Version, v.PublicKey, v.KDF, v.KDFParams, v.Cipher})
+	return b
+}
+
+func randBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := OpenKeyStore(dir)
+	assert(t, err == nil)
+
+	prv1 := NewPrivateKeyFromSeed("key-1")
+	prv2 := NewPrivateKeyFromSeed("key-2")
+
+	// save
+	assert(t, ks.Save("site-1", prv1, "passphrase-1") == nil)
+	assert(t, ks.Save("site-2", prv2, "passphrase-2") == nil)
+	assert(t, ks.Save("site-2", prv1, "passphrase-2") == ErrKeyExists)
+	assert(t, ks.Save("../site-3", prv1, "passphrase-2") == ErrInvalidKeyName)
+
+	// key is not stored in plaintext
+	data, err := os.ReadFile(filepath.Join(dir, "site-1.key"))
+	assert(t, err == nil)
+	assert(t, !bytes.Contains(data, prv1[:32]))
+	assert(t, !bytes.Contains(data, []byte(prv1.Encode()[16:40])))
+
+	// list
+	keys, err := ks.List()
+	assert(t, err == nil)
+	assert(t, len(keys) == 2)
+	assert(t, keys[0].Name == "site-1" && keys[0].PublicKey.Equal(prv1.PublicKey()))
+	assert(t, keys[1].Name == "site-2" && keys[1].PublicKey.Equal(prv2.PublicKey()))
+
+	// load
+	prv, err := ks.Load("site-1", "passphrase-1")
+	assert(t, err == nil)
+	assert(t, bytes.Equal(prv, prv1))
+
+	_, err = ks.Load("site-1", "passphrase-2")
+	assert(t, err == ErrInvalidPassphrase)
+
+	_, err = ks.Load("site-3", "passphrase-1")
+	assert(t, err == ErrKeyNotFound)
+}
+
+func TestKeyStore_concurrentSave(t *testing.T) {
+	ks, err := OpenKeyStore(t.TempDir())
+	assert(t, err == nil)
+
+	keys := []PrivateKey{NewPrivateKeyFromSeed("key-1"), NewPrivateKeyFromSeed("key-2"), NewPrivateKeyFromSeed("key-3")}
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, prv := range keys {
+		wg.Add(1)
+		go func(i int, prv PrivateKey) {
+			defer wg.Done()
+			errs[i] = ks.Save("site", prv, "passphrase")
+		}(i, prv)
+	}
+	wg.Wait()
+
+	// only one key is saved; others are not overwritten
+	var saved PrivateKey
+	for i, err := range errs {
+		assert(t, err == nil || err == ErrKeyExists)
+		if err == nil {
+			assert(t, saved == nil)
+			saved = keys[i]
+		}
+	}
+	prv, err := ks.Load("site", "passphrase")
+	assert(t, err == nil)
+	assert(t, bytes.Equal(prv, saved))
+}
+
+func TestDecryptPrivateKey_corrupted(t *testing.T) {
+	prv := NewPrivateKeyFromSeed("key-1")
+	data, err := EncryptPrivateKey(prv, "passphrase")
+	assert(t, err == nil)
+
+	// replace public key in metadata
+	data = bytes.Replace(data, []byte(prv.PublicKey().Encode()), []byte(NewPrivateKeyFromSeed("key-2").PublicKey().Encode()), 1)
+	_, err = DecryptPrivateKey(data, "passphrase")
+	assert(t, err != nil)
+}
@@ -0,0 +1,105 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Key wrapping encrypts a symmetric key for the holder of an Ed25519 private key.
+//
+// Ed25519 keys are converted to X25519 keys (RFC 7748) and the wrapping key is derived from
+// the ephemeral-static Diffie-Hellman secret:
+//
+//	KEK     = SHA-256("dweb-key-wrap:" | secret | ephemeral public | recipient public)
+//	wrapped = ephemeral public (32 bytes) | AES-256-GCM(KEK, zero nonce, key)
+//
+// Every KEK is used once, so the zero nonce is safe.
+const wrapEphemeralSize = curve25519.PointSize
+
+var (
+	ErrInvalidWrappedKey = errors.New("invalid wrapped key")
+
+	errInvalidPublicKey  = errors.New("invalid public key")
+	errInvalidPrivateKey = errors.New("invalid private key")
+)
+
+// WrapKey encrypts the key for the owner of Ed25519 public key pub
+func WrapKey(pub PublicKey, key []byte) ([]byte, error) {
+	to, err := pub.x25519()
+	if err != nil {
+		return nil, err
+	}
+	eph := make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(eph); err != nil {
+		return nil, err
+	}
+	ephPub, err := curve25519.X25519(eph, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := curve25519.X25519(eph, to)
+	if err != nil {
+		return nil, err
+	}
+	aead := newWrapAEAD(secret, ephPub, to)
+	return aead.Seal(ephPub, make([]byte, aead.NonceSize()), key, nil), nil
+}
+
+// UnwrapKey decrypts the key wrapped by WrapKey for the public key of prv
+func (prv PrivateKey) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) <= wrapEphemeralSize {
+		return nil, ErrInvalidWrappedKey
+	}
+	sk, err := prv.x25519()
+	if err != nil {
+		return nil, err
+	}
+	to, err := prv.PublicKey().x25519()
+	if err != nil {
+		return nil, err
+	}
+	ephPub := wrapped[:wrapEphemeralSize]
+	secret, err := curve25519.X25519(sk, ephPub)
+	if err != nil {
+		return nil, ErrInvalidWrappedKey
+	}
+	aead := newWrapAEAD(secret, ephPub, to)
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped[wrapEphemeralSize:], nil)
+	if err != nil {
+		return nil, ErrInvalidWrappedKey
+	}
+	return key, nil
+}
+
+func newWrapAEAD(secret, ephPub, to []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(SHA256.Hash([]byte("dweb-key-wrap:"), secret, ephPub, to))
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// x25519 returns the Montgomery form of Ed25519 public key
+func (pub PublicKey) x25519() ([]byte, error) {
+	if len(pub) != PublicKeySize {
+		return nil, errInvalidPublicKey
+	}
+	p, err := new(edwards25519.Point).SetBytes(pub)
+	if err != nil {
+		return nil, errInvalidPublicKey
+	}
+	return p.BytesMontgomery(), nil
+}
+
+// x25519 returns X25519 scalar of Ed25519 private key (the clamping is done by curve25519.X25519)
+func (prv PrivateKey) x25519() ([]byte, error) {
+	if len(prv) != ed25519.PrivateKeySize {
+		return nil, errInvalidPrivateKey
+	}
+	h := sha512.Sum512(prv[:ed25519.SeedSize])
+	return h[:curve25519.ScalarSize], nil
+}
@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+	prv := NewPrivateKeyFromSeed("reader")
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	wrapped, err := WrapKey(prv.PublicKey(), key)
+	assert(t, err == nil)
+	assert(t, !bytes.Contains(wrapped, key))
+
+	key1, err := prv.UnwrapKey(wrapped)
+	assert(t, err == nil)
+	assert(t, bytes.Equal(key1, key))
+
+	// each wrapping uses a new ephemeral key
+	wrapped2, _ := WrapKey(prv.PublicKey(), key)
+	assert(t, !bytes.Equal(wrapped, wrapped2))
+}
+
+func TestWrapKey_fail(t *testing.T) {
+	prv := NewPrivateKeyFromSeed("reader")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	wrapped, _ := WrapKey(prv.PublicKey(), key)
+
+	// another key
+	_, err := NewPrivateKeyFromSeed("other").UnwrapKey(wrapped)
+	assert(t, err == ErrInvalidWrappedKey)
+
+	// corrupted
+	wrapped[len(wrapped)-1] ^= 1
+	_, err = prv.UnwrapKey(wrapped)
+	assert(t, err == ErrInvalidWrappedKey)
+
+	_, err = prv.UnwrapKey(wrapped[:32])
+	assert(t, err == ErrInvalidWrappedKey)
+
+	// invalid public key
+	_, err = WrapKey(PublicKey("short"), key)
+	assert(t, err != nil)
+
+	// invalid private key
+	_, err = PrivateKey("short").UnwrapKey(wrapped)
+	assert(t, err != nil)
+}
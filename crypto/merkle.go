@@ -19,6 +19,7 @@ type MerkleHash interface {
 }
 
 type merkleHash struct {
+	alg      HashAlgorithm
 	partSize int64
 	n        int64
 	hash     hash.Hash
@@ -27,12 +28,17 @@ type merkleHash struct {
 }
 
 func NewMerkleHash(partSize int64) MerkleHash {
+	return DefaultHashAlgorithm.NewMerkleHash(partSize)
+}
+
+func (a HashAlgorithm) NewMerkleHash(partSize int64) MerkleHash {
 	if partSize <= 0 {
 		partSize = math.MaxInt64
 	}
 	return &merkleHash{
+		alg:      a,
 		partSize: partSize,
-		hash:     NewHash(),
+		hash:     a.New(),
 	}
 }
 
@@ -53,7 +59,7 @@ func (h *merkleHash) Write(data []byte) (n int, err error) {
 }
 
 func (h *merkleHash) Root() []byte {
-	return MerkleRoot(h.Leaves()...)
+	return h.alg.MerkleRoot(h.Leaves()...)
 }
 
 func (h *merkleHash) Written() int64 {
@@ -68,30 +74,47 @@ func (h *merkleHash) Leaves() [][]byte {
 	return h.parts
 }
 
+// readyLeaves returns hashes of completed parts
+func (h *merkleHash) readyLeaves() [][]byte {
+	return h.parts
+}
+
 func MerkleRoot(hash ...[]byte) []byte {
-	return MakeMerkleRoot(len(hash), func(i int) []byte {
+	return DefaultHashAlgorithm.MerkleRoot(hash...)
+}
+
+func (a HashAlgorithm) MerkleRoot(hash ...[]byte) []byte {
+	return a.MakeMerkleRoot(len(hash), func(i int) []byte {
 		return hash[i]
 	})
 }
 
 func MakeMerkleRoot(n int, itemHash func(int) []byte) []byte {
-	return merkleRootFn(0, n, itemHash)
+	return DefaultHashAlgorithm.MakeMerkleRoot(n, itemHash)
 }
 
-func merkleRootFn(offset, n int, itemHash func(int) []byte) []byte {
+func (a HashAlgorithm) MakeMerkleRoot(n int, itemHash func(int) []byte) []byte {
+	return a.merkleRootFn(0, n, itemHash)
+}
+
+func (a HashAlgorithm) merkleRootFn(offset, n int, itemHash func(int) []byte) []byte {
 	if n == 0 {
 		return nil
 	} else if n == 1 {
 		return itemHash(offset)
 	}
 	i := merkleMiddle(n)
-	return Hash(
-		merkleRootFn(offset, i, itemHash),
-		merkleRootFn(offset+i, n-i, itemHash),
+	return a.Hash(
+		a.merkleRootFn(offset, i, itemHash),
+		a.merkleRootFn(offset+i, n-i, itemHash),
 	)
 }
 
 func MakeMerkleWitness(hashes [][]byte, i int) (buf []byte) {
+	return DefaultHashAlgorithm.MakeMerkleWitness(hashes, i)
+}
+
+func (a HashAlgorithm) MakeMerkleWitness(hashes [][]byte, i int) (buf []byte) {
 	n := len(hashes)
 	if i < 0 || i >= n {
 		panic("invalid tree")
@@ -101,15 +124,15 @@ func MakeMerkleWitness(hashes [][]byte, i int) (buf []byte) {
 	}
 	if i2 := merkleMiddle(n); i < i2 { // arg=HASH(arg|op)
 		return MerkleWitnessAppend(
-			MakeMerkleWitness(hashes[:i2], i),
+			a.MakeMerkleWitness(hashes[:i2], i),
 			OpRHash,
-			MerkleRoot(hashes[i2:]...),
+			a.MerkleRoot(hashes[i2:]...),
 		)
 	} else { // arg=HASH(op|arg)
 		return MerkleWitnessAppend(
-			MakeMerkleWitness(hashes[i2:], i-i2),
+			a.MakeMerkleWitness(hashes[i2:], i-i2),
 			OpLHash,
-			MerkleRoot(hashes[:i2]...),
+			a.MerkleRoot(hashes[:i2]...),
 		)
 	}
 }
@@ -125,16 +148,20 @@ func merkleMiddle(n int) (i int) {
 }
 
 func VerifyMerkleWitness(hash, root, witness []byte) bool {
-	const opSize = HashSize + 1
+	return DefaultHashAlgorithm.VerifyMerkleWitness(hash, root, witness)
+}
+
+func (a HashAlgorithm) VerifyMerkleWitness(hash, root, witness []byte) bool {
+	opSize := a.Size() + 1
 	for n := len(witness); n > 0; n -= opSize {
 		if n < opSize {
 			return false
 		}
 		switch op, arg := witness[0], witness[1:opSize]; op {
 		case OpRHash:
-			hash = Hash(hash, arg)
+			hash = a.Hash(hash, arg)
 		case OpLHash:
-			hash = Hash(arg, hash)
+			hash = a.Hash(arg, hash)
 		default:
 			return false
 		}
@@ -142,3 +169,42 @@ func VerifyMerkleWitness(hash, root, witness []byte) bool {
 	}
 	return bytes.Equal(hash, root)
 }
+
+// VerifyMerkleWitnessAt verifies that hash is i-th of n leaves of the merkle-tree with given root.
+func VerifyMerkleWitnessAt(hash, root, witness []byte, i, n int) bool {
+	return DefaultHashAlgorithm.VerifyMerkleWitnessAt(hash, root, witness, i, n)
+}
+
+func (a HashAlgorithm) VerifyMerkleWitnessAt(hash, root, witness []byte, i, n int) bool {
+	opSize := a.Size() + 1
+	ops := merkleWitnessOps(i, n)
+	if ops == nil || len(witness) != len(ops)*opSize {
+		return false
+	}
+	for j, op := range ops {
+		if witness[j*opSize] != op {
+			return false
+		}
+	}
+	return a.VerifyMerkleWitness(hash, root, witness)
+}
+
+// merkleWitnessOps returns the sequence of witness operations for i-th of n leaves
+func merkleWitnessOps(i, n int) (ops []byte) {
+	if i < 0 || i >= n {
+		return nil
+	}
+	ops = []byte{}
+	for n > 1 {
+		if i2 := merkleMiddle(n); i < i2 {
+			ops, n = append(ops, OpRHash), i2
+		} else {
+			ops, i, n = append(ops, OpLHash), i-i2, n-i2
+		}
+	}
+	// reverse; witness starts from the bottom of the tree
+	for a, b := 0, len(ops)-1; a < b; a, b = a+1, b-1 {
+		ops[a], ops[b] = ops[b], ops[a]
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package crypto
+
+import "bytes"
+
+// multi-proof operations
+const (
+	OpMultiLeaf = 0 // push next proved leaf
+	OpMultiHash = 1 // push next hash of the proof
+	OpMultiJoin = 2 // pop two items, push HASH(a|b)
+)
+
+// MerkleMultiProof is a compact proof of several leaves of the merkle-tree.
+// Every sibling hash is included in the proof only once.
+type MerkleMultiProof struct {
+	Ops    []byte
+	Hashes [][]byte
+}
+
+// MakeMerkleMultiProof makes a proof for leaves with the given indexes.
+// Leaves are verified in ascending order of indexes.
+func MakeMerkleMultiProof(hashes [][]byte, indexes ...int) *MerkleMultiProof {
+	return DefaultHashAlgorithm.MakeMerkleMultiProof(hashes, indexes...)
+}
+
+func (a HashAlgorithm) MakeMerkleMultiProof(hashes [][]byte, indexes ...int) *MerkleMultiProof {
+	in := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		if i < 0 || i >= len(hashes) {
+			panic("invalid tree")
+		}
+		in[i] = true
+	}
+	p := &MerkleMultiProof{}
+	p.AppendTree(a, len(hashes),
+		func(i int) bool { return in[i] },
+		func(int) { p.AppendLeaf() },
+		func(i int) []byte { return hashes[i] },
+	)
+	return p
+}
+
+// AppendTree appends the proof for n-items subtree,
+// where has(i) says that the i-th item contains proved leaves,
+// sub(i) appends the proof of the i-th item and itemHash(i) returns hash of the i-th item.
+func (p *MerkleMultiProof) AppendTree(a HashAlgorithm, n int, has func(int) bool, sub func(int), itemHash func(int) []byte) {
+	counts := make([]int, n+1) // counts[i] is count of items containing proved leaves before the i-th item
+	for i := 0; i < n; i++ {
+		counts[i+1] = counts[i]
+		if has(i) {
+			counts[i+1]++
+		}
+	}
+	p.appendTree(a, 0, n, counts, sub, itemHash)
+}
+
+func (p *MerkleMultiProof) appendTree(a HashAlgorithm, offset, n int, counts []int, sub func(int), itemHash func(int) []byte) {
+	if n == 0 {
+		return
+	}
+	if counts[offset+n] == counts[offset] { // subtree has no proved leaves
+		p.AppendHash(a.merkleRootFn(offset, n, itemHash))
+		return
+	}
+	if n == 1 {
+		sub(offset)
+		return
+	}
+	i := merkleMiddle(n)
+	p.appendTree(a, offset, i, counts, sub, itemHash)
+	p.appendTree(a, offset+i, n-i, counts, sub, itemHash)
+	p.AppendJoin()
+}
+
+func (p *MerkleMultiProof) AppendLeaf() {
+	p.Ops = append(p.Ops, OpMultiLeaf)
+}
+
+func (p *MerkleMultiProof) AppendHash(hash []byte) {
+	p.Ops = append(p.Ops, OpMultiHash)
+	p.Hashes = append(p.Hashes, hash)
+}
+
+func (p *MerkleMultiProof) AppendJoin() {
+	p.Ops = append(p.Ops, OpMultiJoin)
+}
+
+// Root returns the merkle-root calculated from proved leaves.
+// It returns nil if the proof is malformed or does not match leaves.
+func (p *MerkleMultiProof) Root(leaves [][]byte) []byte {
+	return p.RootBy(DefaultHashAlgorithm, leaves)
+}
+
+// RootBy returns the merkle-root calculated from proved leaves with the hash algorithm.
+func (p *MerkleMultiProof) RootBy(a HashAlgorithm, leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	hashes := p.Hashes
+	var stack [][]byte
+	for _, op := range p.Ops {
+		switch op {
+		case OpMultiLeaf:
+			if len(leaves) == 0 {
+				return nil
+			}
+			stack, leaves = append(stack, leaves[0]), leaves[1:]
+		case OpMultiHash:
+			if len(hashes) == 0 {
+				return nil
+			}
+			stack, hashes = append(stack, hashes[0]), hashes[1:]
+		case OpMultiJoin:
+			n := len(stack)
+			if n < 2 {
+				return nil
+			}
+			stack = append(stack[:n-2], a.Hash(stack[n-2], stack[n-1]))
+		default:
+			return nil
+		}
+	}
+	if len(stack) != 1 || len(leaves) != 0 || len(hashes) != 0 {
+		return nil
+	}
+	return stack[0]
+}
+
+// VerifyMerkleMultiProof verifies that leaves belong to the merkle-tree with given root
+func VerifyMerkleMultiProof(leaves [][]byte, root []byte, p *MerkleMultiProof) bool {
+	return DefaultHashAlgorithm.VerifyMerkleMultiProof(leaves, root, p)
+}
+
+func (a HashAlgorithm) VerifyMerkleMultiProof(leaves [][]byte, root []byte, p *MerkleMultiProof) bool {
+	r := p.RootBy(a, leaves)
+	return r != nil && bytes.Equal(r, root)
+}
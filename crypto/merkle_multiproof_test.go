@@ -0,0 +1,36 @@
+package crypto
+
+import "testing"
+
+func TestMakeMerkleMultiProof(t *testing.T) {
+	for n := 1; n < 20; n++ {
+		hashes := make([][]byte, n)
+		for i := range hashes {
+			hashes[i] = Hash([]byte{byte(i)})
+		}
+		root := MerkleRoot(hashes...)
+		for i := 0; i < n; i++ {
+			for j := i; j < n; j++ {
+				p := MakeMerkleMultiProof(hashes, i, j)
+				leaves := [][]byte{hashes[i], hashes[j]}
+				if i == j {
+					leaves = leaves[:1]
+				}
+				assert(t, VerifyMerkleMultiProof(leaves, root, p))
+				assert(t, !VerifyMerkleMultiProof(leaves[:1], root, p) || i == j)
+				assert(t, !VerifyMerkleMultiProof([][]byte{Hash(), hashes[j]}, root, p))
+			}
+		}
+	}
+}
+
+func TestMakeMerkleMultiProof_compact(t *testing.T) {
+	hashes := make([][]byte, 16)
+	for i := range hashes {
+		hashes[i] = Hash([]byte{byte(i)})
+	}
+	p := MakeMerkleMultiProof(hashes, 0, 1, 2, 3)
+
+	assert(t, len(p.Hashes) == 2) // 4 witnesses would contain 16 hashes
+	assert(t, VerifyMerkleMultiProof(hashes[:4], MerkleRoot(hashes...), p))
+}
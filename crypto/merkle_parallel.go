@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"runtime"
+	"sync"
+)
+
+// MaxParallelPartSize is the max part size for that parts are hashed in parallel.
+// MerkleHash with greater part size is calculated by one goroutine.
+const MaxParallelPartSize = 64 << 20 // 64 MiB
+
+// maxParallelMemory is the max size of part-buffers of one parallel MerkleHash
+const maxParallelMemory = 256 << 20 // 256 MiB
+
+type parallelMerkleHash struct {
+	alg      HashAlgorithm
+	partSize int
+	workers  int
+	n        int64
+	buf      []byte      // current part
+	free     chan []byte // free part-buffers
+	nBufs    int         // count of allocated part-buffers
+	wg       sync.WaitGroup
+	mx       sync.Mutex
+	parts    [][]byte
+	nReady   int // count of hashed parts at the beginning of parts
+}
+
+// NewParallelMerkleHash returns MerkleHash that hashes parts by several goroutines.
+// It keeps at most workers parts in memory (workers × partSize bytes);
+// workers <= 0 means runtime.GOMAXPROCS(0). Count of workers is limited so that parts take at most 256 MiB.
+func NewParallelMerkleHash(partSize int64, workers int) MerkleHash {
+	return DefaultHashAlgorithm.NewParallelMerkleHash(partSize, workers)
+}
+
+func (a HashAlgorithm) NewParallelMerkleHash(partSize int64, workers int) MerkleHash {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if partSize > 0 && int64(workers)*partSize > maxParallelMemory {
+		workers = int(maxParallelMemory / partSize)
+	}
+	if partSize <= 0 || partSize > MaxParallelPartSize || workers == 1 {
+		return a.NewMerkleHash(partSize)
+	}
+	return &parallelMerkleHash{
+		alg:      a,
+		partSize: int(partSize),
+		workers:  workers,
+		free:     make(chan []byte, workers),
+	}
+}
+
+func (h *parallelMerkleHash) Write(data []byte) (n int, err error) {
+	n = len(data)
+	h.n += int64(n)
+	for len(data) > 0 {
+		if h.buf == nil {
+			h.buf = h.allocBuffer()
+		}
+		m := copy(h.buf[len(h.buf):h.partSize], data)
+		h.buf, data = h.buf[:len(h.buf)+m], data[m:]
+		if len(h.buf) == h.partSize {
+			h.flush()
+		}
+	}
+	return
+}
+
+func (h *parallelMerkleHash) allocBuffer() []byte {
+	if len(h.free) == 0 && h.nBufs < h.workers {
+		h.nBufs++
+		return make([]byte, 0, h.partSize)
+	}
+	return <-h.free // wait for a free buffer
+}
+
+func (h *parallelMerkleHash) flush() {
+	buf := h.buf
+	h.buf = nil
+
+	h.mx.Lock()
+	i := len(h.parts)
+	h.parts = append(h.parts, nil)
+	h.mx.Unlock()
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		hash := h.alg.Hash(buf)
+		h.free <- buf[:0]
+
+		h.mx.Lock()
+		defer h.mx.Unlock()
+		h.parts[i] = hash
+		for h.nReady < len(h.parts) && h.parts[h.nReady] != nil {
+			h.nReady++
+		}
+	}()
+}
+
+func (h *parallelMerkleHash) Root() []byte {
+	return h.alg.MerkleRoot(h.Leaves()...)
+}
+
+func (h *parallelMerkleHash) Written() int64 {
+	return h.n
+}
+
+func (h *parallelMerkleHash) Leaves() [][]byte {
+	if len(h.buf) > 0 {
+		h.flush()
+	}
+	h.wg.Wait()
+	return h.parts
+}
+
+// readyLeaves returns hashed parts in order without waiting for others
+func (h *parallelMerkleHash) readyLeaves() [][]byte {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.parts[:h.nReady]
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestNewParallelMerkleHash(t *testing.T) {
+	const partSize = 1 << 20 // 1MiB
+	hash := NewParallelMerkleHash(partSize, 4)
+
+	// write random data
+	n, err := io.Copy(hash, io.LimitReader(rand.New(rand.NewSource(0)), 20e6))
+
+	assert(t, n == 20e6)
+	assert(t, err == nil)
+	assert(t, len(hash.Leaves()) == 20)
+	assert(t, hash.(*parallelMerkleHash).nBufs <= 4)
+	assert(t, hex.EncodeToString(hash.Root()) == "1504aceff010d04d5ee597bf33d2195491d3534b5e28e9c08259bc4d50373490")
+
+	// memory of part-buffers is limited
+	assert(t, NewParallelMerkleHash(MaxParallelPartSize, 64).(*parallelMerkleHash).workers == 4)
+}
+
+func TestNewParallelMerkleHash_equalsSerial(t *testing.T) {
+	data := testRandomData(100e3)
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, partSize := range []int64{0, 1, 100, 1000, 1024, 99999, 100e3, 200e3} {
+		for _, alg := range []HashAlgorithm{SHA256, BLAKE3} {
+			h1 := alg.NewMerkleHash(partSize)
+			h2 := alg.NewParallelMerkleHash(partSize, 3)
+			for buf := data; len(buf) > 0; { // write data by random chunks
+				n := rnd.Intn(3000) + 1
+				if n > len(buf) {
+					n = len(buf)
+				}
+				h1.Write(buf[:n])
+				h2.Write(buf[:n])
+				buf = buf[n:]
+			}
+			assert(t, h1.Written() == h2.Written())
+			assert(t, len(h1.Leaves()) == len(h2.Leaves()))
+			for i, leaf := range h1.Leaves() {
+				assert(t, bytes.Equal(leaf, h2.Leaves()[i]))
+			}
+			assert(t, bytes.Equal(h1.Root(), h2.Root()))
+		}
+	}
+}
+
+func BenchmarkMerkleHash(b *testing.B) {
+	data := testRandomData(64 << 20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		h := NewMerkleHash(1 << 20)
+		h.Write(data)
+		h.Root()
+	}
+}
+
+func BenchmarkParallelMerkleHash(b *testing.B) {
+	data := testRandomData(64 << 20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		h := NewParallelMerkleHash(1<<20, 0)
+		h.Write(data)
+		h.Root()
+	}
+}
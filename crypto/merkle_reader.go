@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var (
+	ErrMerklePartMismatch = errors.New("merkle: invalid part hash")
+	ErrMerkleRootMismatch = errors.New("merkle: invalid root hash")
+)
+
+type merkleReader struct {
+	r      io.Reader
+	size   int64
+	hash   merkleLeavesHash
+	root   []byte   // expected merkle-root
+	parts  [][]byte // expected part-hashes
+	nParts int      // number of verified parts
+	err    error
+}
+
+// NewMerkleReader returns a reader that reads exactly size bytes from r and
+// fails if the merkle-root of read data does not equal root.
+func NewMerkleReader(r io.Reader, size, partSize int64, root []byte) io.Reader {
+	return DefaultHashAlgorithm.NewMerkleReader(r, size, partSize, root)
+}
+
+func (a HashAlgorithm) NewMerkleReader(r io.Reader, size, partSize int64, root []byte) io.Reader {
+	return &merkleReader{
+		r:    r,
+		size: size,
+		hash: a.newReaderHash(size, partSize),
+		root: root,
+	}
+}
+
+// NewMerklePartsReader returns a reader that reads exactly size bytes from r and
+// fails on the first part of data whose hash does not equal the expected part-hash.
+func NewMerklePartsReader(r io.Reader, size, partSize int64, parts [][]byte) io.Reader {
+	return DefaultHashAlgorithm.NewMerklePartsReader(r, size, partSize, parts)
+}
+
+func (a HashAlgorithm) NewMerklePartsReader(r io.Reader, size, partSize int64, parts [][]byte) io.Reader {
+	return &merkleReader{
+		r:     r,
+		size:  size,
+		hash:  a.NewMerkleHash(partSize).(merkleLeavesHash), // parts are checked as soon as they are read
+		root:  a.MerkleRoot(parts...),
+		parts: parts,
+	}
+}
+
+type merkleLeavesHash interface {
+	MerkleHash
+	readyLeaves() [][]byte
+}
+
+// newReaderHash returns parallel MerkleHash for data of several parts.
+// Parallel hash returns hashes of read parts with delay, so it is used only for the check of the whole data root.
+func (a HashAlgorithm) newReaderHash(size, partSize int64) merkleLeavesHash {
+	if partSize > 0 && size > partSize {
+		return a.NewParallelMerkleHash(partSize, 0).(merkleLeavesHash)
+	}
+	return a.NewMerkleHash(partSize).(merkleLeavesHash)
+}
+
+func (r *merkleReader) Read(buf []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if rest := r.size - r.hash.Written(); int64(len(buf)) > rest {
+		buf = buf[:rest]
+	}
+	if len(buf) > 0 {
+		n, err = r.r.Read(buf)
+		r.hash.Write(buf[:n])
+		if err == io.EOF && r.hash.Written() < r.size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			r.err = err
+			return
+		}
+		if err = r.verifyParts(r.hash.readyLeaves()); err != nil {
+			return 0, err
+		}
+	}
+	if r.hash.Written() == r.size { // all data has been read
+		if err = r.verifyParts(r.hash.Leaves()); err == nil && !bytes.Equal(r.hash.Root(), r.root) {
+			r.err, err = ErrMerkleRootMismatch, ErrMerkleRootMismatch
+		}
+		if err == nil && n == 0 {
+			err = io.EOF
+		}
+		if err != nil {
+			n = 0
+		}
+	}
+	return
+}
+
+func (r *merkleReader) verifyParts(hashes [][]byte) error {
+	if r.parts == nil {
+		return nil
+	}
+	for ; r.nParts < len(hashes); r.nParts++ {
+		if r.nParts >= len(r.parts) || !bytes.Equal(hashes[r.nParts], r.parts[r.nParts]) {
+			r.err = ErrMerklePartMismatch
+			return r.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestNewMerkleReader(t *testing.T) {
+	const partSize = 1024
+	data := testRandomData(10e3)
+	root := merkleRootOf(data, partSize)
+
+	r := NewMerkleReader(bytes.NewReader(data), int64(len(data)), partSize, root)
+	res, err := io.ReadAll(r)
+
+	assert(t, err == nil)
+	assert(t, bytes.Equal(res, data))
+}
+
+func TestNewMerkleReader_fail(t *testing.T) {
+	const partSize = 1024
+	data := testRandomData(10e3)
+	root := merkleRootOf(data, partSize)
+	data[5000]++ // corrupt data
+
+	r := NewMerkleReader(bytes.NewReader(data), int64(len(data)), partSize, root)
+	_, err := io.ReadAll(r)
+
+	assert(t, err == ErrMerkleRootMismatch)
+}
+
+func TestNewMerkleReader_unexpectedEOF(t *testing.T) {
+	const partSize = 1024
+	data := testRandomData(10e3)
+	root := merkleRootOf(data, partSize)
+
+	r := NewMerkleReader(bytes.NewReader(data[:9000]), int64(len(data)), partSize, root)
+	_, err := io.ReadAll(r)
+
+	assert(t, err == io.ErrUnexpectedEOF)
+}
+
+func TestNewMerklePartsReader(t *testing.T) {
+	const partSize = 1024
+	data := testRandomData(10e3)
+	h := NewMerkleHash(partSize)
+	h.Write(data)
+
+	r := NewMerklePartsReader(bytes.NewReader(data), int64(len(data)), partSize, h.Leaves())
+	res, err := io.ReadAll(r)
+
+	assert(t, err == nil)
+	assert(t, bytes.Equal(res, data))
+}
+
+func TestNewMerklePartsReader_failOnFirstInvalidPart(t *testing.T) {
+	const partSize = 1024
+	data := testRandomData(10e3)
+	h := NewMerkleHash(partSize)
+	h.Write(data)
+	parts := h.Leaves()
+	data[1500]++ // corrupt the 2nd part
+
+	r := NewMerklePartsReader(bytes.NewReader(data), int64(len(data)), partSize, parts)
+	buf := make([]byte, partSize)
+	_, err1 := io.ReadFull(r, buf)
+	_, err2 := io.ReadFull(r, buf)
+	_, err3 := io.ReadFull(r, buf)
+
+	assert(t, err1 == nil)
+	assert(t, err2 == ErrMerklePartMismatch)
+	assert(t, err3 == ErrMerklePartMismatch)
+}
+
+func merkleRootOf(data []byte, partSize int64) []byte {
+	h := NewMerkleHash(partSize)
+	h.Write(data)
+	return h.Root()
+}
+
+func testRandomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(0)).Read(data)
+	return data
+}
@@ -45,3 +45,21 @@ func TestNewHash(t *testing.T) {
 	assert(t, err == nil)
 	assert(t, hex.EncodeToString(hash.Sum(nil)) == "9c9f54aca340d76dd36acd53069805bed7aca84f28b1a6bc2c7d27f7f06fac20")
 }
+
+func TestVerifyMerkleWitnessAt(t *testing.T) {
+	for n := 1; n < 20; n++ {
+		hashes := make([][]byte, n)
+		for i := range hashes {
+			hashes[i] = Hash([]byte{byte(i)})
+		}
+		root := MerkleRoot(hashes...)
+		for i := 0; i < n; i++ {
+			witness := MakeMerkleWitness(hashes, i)[HashSize:]
+
+			assert(t, VerifyMerkleWitnessAt(hashes[i], root, witness, i, n))
+			if i > 0 {
+				assert(t, !VerifyMerkleWitnessAt(hashes[i], root, witness, i-1, n)) // wrong position
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// MaxMultiKeys is the max count of keys of the multi-signature public key
+const MaxMultiKeys = 16
+
+// multiKeyEncodingPrefix is the encoding prefix of M-of-N public key.
+// Binary format of the key: [threshold M][count N][N x 32-byte Ed25519 public keys]
+const multiKeyEncodingPrefix = "Ed25519-Multi,"
+
+// multiSigItemSize is the size of one signature of the multi-signature.
+// Binary format of multi-signature: sequence of [key index][64-byte Ed25519 signature] sorted by key index
+const multiSigItemSize = 1 + SignatureSize
+
+var (
+	ErrInvalidMultiKey       = errors.New("crypto: invalid multi-signature public key")
+	ErrInvalidMultiSignature = errors.New("crypto: invalid multi-signature")
+	ErrNotMultiKeySigner     = errors.New("crypto: private key is not a signer of the multi-signature public key")
+)
+
+// NewMultiPublicKey returns public key that requires m valid signatures of the given n keys.
+func NewMultiPublicKey(m int, keys ...PublicKey) (PublicKey, error) {
+	n := len(keys)
+	if m < 1 || m > n || n > MaxMultiKeys {
+		return nil, ErrInvalidMultiKey
+	}
+	pub := PublicKey{byte(m), byte(n)}
+	for _, k := range keys {
+		if len(k) != PublicKeySize {
+			return nil, ErrInvalidMultiKey
+		}
+		pub = append(pub, k...)
+	}
+	if !pub.IsMulti() { // duplicate keys
+		return nil, ErrInvalidMultiKey
+	}
+	return pub, nil
+}
+
+// IsMulti says that pub is M-of-N multi-signature public key
+func (pub PublicKey) IsMulti() bool {
+	if len(pub) < 2 {
+		return false
+	}
+	m, n := int(pub[0]), int(pub[1])
+	if m < 1 || m > n || n > MaxMultiKeys || len(pub) != 2+n*PublicKeySize {
+		return false
+	}
+	for i := 1; i < n; i++ { // keys are unique
+		for j := 0; j < i; j++ {
+			if bytes.Equal(pub[2+i*PublicKeySize:2+(i+1)*PublicKeySize], pub[2+j*PublicKeySize:2+(j+1)*PublicKeySize]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Threshold returns the count of signatures required by the multi-signature public key (M)
+func (pub PublicKey) Threshold() int {
+	if !pub.IsMulti() {
+		return 1
+	}
+	return int(pub[0])
+}
+
+// Keys returns the public keys of the multi-signature signers
+func (pub PublicKey) Keys() (keys []PublicKey) {
+	if !pub.IsMulti() {
+		return []PublicKey{pub}
+	}
+	for i, n := 0, int(pub[1]); i < n; i++ {
+		keys = append(keys, pub[2+i*PublicKeySize:2+(i+1)*PublicKeySize])
+	}
+	return
+}
+
+func (pub PublicKey) keyIndex(k PublicKey) int {
+	for i, k1 := range pub.Keys() {
+		if bytes.Equal(k, k1) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (pub PublicKey) verifyMulti(message, signature []byte) bool {
+	ss, err := pub.splitMultiSignature(signature)
+	if err != nil || len(ss) < pub.Threshold() {
+		return false
+	}
+	keys := pub.Keys()
+	for _, s := range ss {
+		if !keys[s[0]].Verify(message, s[1:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitMultiSignature splits the multi-signature to items [key-index][signature]
+func (pub PublicKey) splitMultiSignature(signature []byte) (ss [][]byte, err error) {
+	if !pub.IsMulti() || len(signature)%multiSigItemSize != 0 {
+		return nil, ErrInvalidMultiSignature
+	}
+	n := int(pub[1])
+	for i := 0; i < len(signature); i += multiSigItemSize {
+		s := signature[i : i+multiSigItemSize]
+		if int(s[0]) >= n || len(ss) > 0 && s[0] <= ss[len(ss)-1][0] { // index is out of range or not sorted
+			return nil, ErrInvalidMultiSignature
+		}
+		ss = append(ss, s)
+	}
+	return
+}
+
+// MultiSign makes a partial signature of the message by one of the keys of the multi-signature public key.
+// Partial signatures of several signers are joined by MergeMultiSignatures.
+func (prv PrivateKey) MultiSign(pub PublicKey, message []byte) ([]byte, error) {
+	return MultiSign(prv, pub, message)
+}
+
+// MultiSign makes a partial signature of the message by the signer that is one of the keys of the multi-signature public key.
+func MultiSign(s Signer, pub PublicKey, message []byte) ([]byte, error) {
+	if !pub.IsMulti() {
+		return nil, ErrInvalidMultiKey
+	}
+	i := pub.keyIndex(s.PublicKey())
+	if i < 0 {
+		return nil, ErrNotMultiKeySigner
+	}
+	sig, err := s.SignDigest(message)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(i)}, sig...), nil
+}
+
+// MergeMultiSignatures joins partial multi-signatures of the message.
+// Every signature is verified; the signatures of the same signer are joined once.
+func MergeMultiSignatures(pub PublicKey, message []byte, signatures ...[]byte) ([]byte, error) {
+	keys := pub.Keys()
+	bySigner := map[byte][]byte{}
+	for _, sig := range signatures {
+		ss, err := pub.splitMultiSignature(sig)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range ss {
+			if !keys[s[0]].Verify(message, s[1:]) {
+				return nil, ErrInvalidMultiSignature
+			}
+			bySigner[s[0]] = s
+		}
+	}
+	var ss [][]byte
+	for _, s := range bySigner {
+		ss = append(ss, s)
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i][0] < ss[j][0] })
+	return bytes.Join(ss, nil), nil
+}
+
+// SignersCount returns the count of the valid signatures of the multi-signature
+func (pub PublicKey) SignersCount(message, signature []byte) (n int) {
+	ss, err := pub.splitMultiSignature(signature)
+	if err != nil {
+		return 0
+	}
+	keys := pub.Keys()
+	for _, s := range ss {
+		if keys[s[0]].Verify(message, s[1:]) {
+			n++
+		}
+	}
+	return
+}
+
+func decodeMultiPublicKey(s string) PublicKey {
+	if !strings.HasPrefix(s, multiKeyEncodingPrefix) {
+		return nil
+	}
+	if p, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, multiKeyEncodingPrefix)); PublicKey(p).IsMulti() {
+		return p
+	}
+	return nil
+}
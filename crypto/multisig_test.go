@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testMultiKey(m int) (PublicKey, []PrivateKey) {
+	var prvs []PrivateKey
+	var pubs []PublicKey
+	for _, seed := range []string{"signer-1", "signer-2", "signer-3"} {
+		prv := NewPrivateKeyFromSeed(seed)
+		prvs, pubs = append(prvs, prv), append(pubs, prv.PublicKey())
+	}
+	pub, err := NewMultiPublicKey(m, pubs...)
+	if err != nil {
+		panic(err)
+	}
+	return pub, prvs
+}
+
+func TestNewMultiPublicKey(t *testing.T) {
+	pub, prvs := testMultiKey(2)
+
+	assert(t, pub.IsMulti())
+	assert(t, pub.Threshold() == 2)
+	assert(t, len(pub.Keys()) == 3)
+	assert(t, pub.Keys()[1].Equal(prvs[1].PublicKey()))
+
+	pub2 := DecodePublicKey(pub.Encode())
+	assert(t, pub2.IsMulti())
+	assert(t, pub.Equal(pub2))
+	assert(t, !pub.Equal(prvs[0].PublicKey()))
+
+	k := prvs[0].PublicKey()
+	_, err := NewMultiPublicKey(2, k, k) // duplicate keys
+	assert(t, err == ErrInvalidMultiKey)
+	_, err = NewMultiPublicKey(3, k, prvs[1].PublicKey())
+	assert(t, err == ErrInvalidMultiKey)
+	_, err = NewMultiPublicKey(0, k)
+	assert(t, err == ErrInvalidMultiKey)
+
+	assert(t, !k.IsMulti())
+	assert(t, DecodePublicKey("Ed25519-Multi,AgI=") == nil)
+}
+
+func TestPublicKey_Verify_multi(t *testing.T) {
+	pub, prvs := testMultiKey(2)
+	msg := []byte("message")
+
+	sig1, err := prvs[0].MultiSign(pub, msg)
+	assert(t, err == nil)
+	sig3, err := prvs[2].MultiSign(pub, msg)
+	assert(t, err == nil)
+	_, err = NewPrivateKeyFromSeed("other").MultiSign(pub, msg)
+	assert(t, err == ErrNotMultiKeySigner)
+
+	assert(t, !pub.Verify(msg, sig1)) // 1 of 2
+
+	sig, err := MergeMultiSignatures(pub, msg, sig3, sig1, sig1)
+	assert(t, err == nil)
+	assert(t, len(sig) == 2*multiSigItemSize)
+	assert(t, pub.SignersCount(msg, sig) == 2)
+	assert(t, pub.Verify(msg, sig))
+	assert(t, !pub.Verify([]byte("other message"), sig))
+
+	// the same signature twice
+	assert(t, !pub.Verify(msg, append(append([]byte{}, sig1...), sig1...)))
+
+	// not sorted signatures
+	assert(t, !pub.Verify(msg, append(append([]byte{}, sig3...), sig1...)))
+
+	// invalid partial signature
+	sig2 := append([]byte{1}, sig1[1:]...)
+	_, err = MergeMultiSignatures(pub, msg, sig1, sig2)
+	assert(t, err == ErrInvalidMultiSignature)
+
+	// merged signature is extended
+	sig2, _ = prvs[1].MultiSign(pub, msg)
+	sig, err = MergeMultiSignatures(pub, msg, sig, sig2)
+	assert(t, err == nil)
+	assert(t, pub.SignersCount(msg, sig) == 3)
+	assert(t, bytes.HasPrefix(sig, sig1))
+	assert(t, pub.Verify(msg, sig))
+}
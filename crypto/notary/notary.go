@@ -0,0 +1,165 @@
+// Package notary implements a timestamping notary service that countersigns hashes of root-headers.
+//
+// The notary is a small HTTP service:
+//
+//	GET  /key                     -> {"Key":"Ed25519,..."}
+//	POST /attest {"Hash":"..."}   -> crypto.Attestation
+//
+// Failed requests are answered by HTTP error status and {"Error":"..."}.
+package notary
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/denisskin/dweb/crypto"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxMessageSize = 64 << 10
+	maxHashSize    = 64
+	requestTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidRequest     = errors.New("notary: invalid request")
+	ErrInvalidAttestation = errors.New("notary: invalid attestation")
+)
+
+type request struct {
+	Hash []byte `json:",omitempty"`
+}
+
+type response struct {
+	Key   string `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+// Server is HTTP handler of the notary that attests hashes by the current time
+type Server struct {
+	signer crypto.Signer
+	now    func() time.Time
+}
+
+func New(signer crypto.Signer) *Server {
+	return &Server{signer, time.Now}
+}
+
+// ListenAndServe listens on the TCP network address and serves requests of clients
+func (s *Server) ListenAndServe(addr string) error {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      s,
+		ReadTimeout:  requestTimeout,
+		WriteTimeout: requestTimeout,
+	}
+	return srv.ListenAndServe()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/key":
+		writeJSON(w, http.StatusOK, response{Key: s.signer.PublicKey().Encode()})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/attest":
+		var req request
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxMessageSize)).Decode(&req); err != nil || len(req.Hash) == 0 || len(req.Hash) > maxHashSize {
+			writeJSON(w, http.StatusBadRequest, response{Error: ErrInvalidRequest.Error()})
+			return
+		}
+		a, err := crypto.NewAttestation(s.signer, req.Hash, s.now())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, response{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+
+	default:
+		writeJSON(w, http.StatusNotFound, response{Error: ErrInvalidRequest.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client is a client of the notary service
+type Client struct {
+	url    string
+	notary crypto.PublicKey
+	client *http.Client
+}
+
+// NewClient returns client of the notary service with the base URL.
+// Attestations are accepted from the notary key only.
+func NewClient(url string, notary crypto.PublicKey) *Client {
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		notary: notary,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Key requests public key of the notary service
+func (c *Client) Key() (crypto.PublicKey, error) {
+	var resp response
+	if err := c.call(http.MethodGet, "/key", nil, &resp); err != nil {
+		return nil, err
+	}
+	if pub := crypto.DecodePublicKey(resp.Key); pub != nil {
+		return pub, nil
+	}
+	return nil, ErrInvalidRequest
+}
+
+// Attest requests the notary to attest the hash
+func (c *Client) Attest(hash []byte) (*crypto.Attestation, error) {
+	var a crypto.Attestation
+	if err := c.call(http.MethodPost, "/attest", request{Hash: hash}, &a); err != nil {
+		return nil, err
+	}
+	if !a.Notary.Equal(c.notary) || !a.Verify(hash) {
+		return nil, ErrInvalidAttestation
+	}
+	return &a, nil
+}
+
+func (c *Client) call(method, path string, req, res any) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, maxMessageSize))
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var resp response
+		if json.Unmarshal(data, &resp) == nil && resp.Error == ErrInvalidRequest.Error() {
+			return ErrInvalidRequest
+		}
+		return fmt.Errorf("notary: %s", httpResp.Status)
+	}
+	return json.Unmarshal(data, res)
+}
@@ -0,0 +1,57 @@
+package notary
+
+import (
+	"github.com/denisskin/dweb/crypto"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func assert(t *testing.T, ok bool) {
+	if !ok {
+		t.Helper()
+		t.Fatal()
+	}
+}
+
+func startTestNotary(t *testing.T, prv crypto.PrivateKey, ts time.Time) string {
+	s := New(prv)
+	s.now = func() time.Time { return ts }
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestNotary(t *testing.T) {
+	prv := crypto.NewPrivateKeyFromSeed("notary")
+	ts := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	url := startTestNotary(t, prv, ts)
+
+	pub, err := NewClient(url, nil).Key()
+	assert(t, err == nil)
+	assert(t, pub.Equal(prv.PublicKey()))
+
+	c := NewClient(url, pub)
+	hash := crypto.Hash([]byte("root-header"))
+	a, err := c.Attest(hash)
+	assert(t, err == nil)
+	assert(t, a.Verify(hash))
+	assert(t, a.Time.Equal(ts))
+
+	// invalid hash
+	_, err = c.Attest(nil)
+	assert(t, err == ErrInvalidRequest)
+
+	// untrusted notary
+	_, err = NewClient(url, crypto.NewPrivateKeyFromSeed("other").PublicKey()).Attest(hash)
+	assert(t, err == ErrInvalidAttestation)
+}
+
+func TestClient_noNotary(t *testing.T) {
+	srv := httptest.NewServer(New(crypto.NewPrivateKeyFromSeed("notary")))
+	url := srv.URL
+	srv.Close()
+
+	_, err := NewClient(url, nil).Key()
+	assert(t, err != nil)
+}
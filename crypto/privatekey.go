@@ -1,14 +1,18 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"encoding/base64"
+	"strings"
 )
 
 type PrivateKey []byte
 
 const SignatureSize = ed25519.SignatureSize
 
+const privateKeyEncodingPrefix = "PRIVATE:Ed25519,"
+
 func NewPrivateKeyFromSeed(seed string) PrivateKey {
 	return PrivateKey(ed25519.NewKeyFromSeed(Hash([]byte(seed))))
 }
@@ -18,7 +22,21 @@ func (prv PrivateKey) String() string {
 }
 
 func (prv PrivateKey) Encode() string {
-	return "PRIVATE:Ed25519," + base64.StdEncoding.EncodeToString(prv)
+	return privateKeyEncodingPrefix + base64.StdEncoding.EncodeToString(prv)
+}
+
+func DecodePrivateKey(s string) PrivateKey {
+	if !strings.HasPrefix(s, privateKeyEncodingPrefix) {
+		return nil
+	}
+	p, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, privateKeyEncodingPrefix))
+	if len(p) != ed25519.PrivateKeySize {
+		return nil
+	}
+	if prv := ed25519.NewKeyFromSeed(p[:ed25519.SeedSize]); !bytes.Equal(prv, p) { // public part does not match the seed
+		return nil
+	}
+	return p
 }
 
 func (prv PrivateKey) SubKey(name string) PrivateKey {
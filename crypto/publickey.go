@@ -18,20 +18,30 @@ func (pub PublicKey) String() string {
 }
 
 func (pub PublicKey) Encode() string {
+	if pub.IsMulti() {
+		return multiKeyEncodingPrefix + base64.StdEncoding.EncodeToString(pub)
+	}
 	return publicKeyEncodingPrefix + base64.StdEncoding.EncodeToString(pub)
 }
 
 func (pub PublicKey) Equal(p PublicKey) bool {
-	return len(pub) == PublicKeySize && bytes.Equal(pub, p)
+	return (len(pub) == PublicKeySize || pub.IsMulti()) && bytes.Equal(pub, p)
 }
 
+// Verify verifies Ed25519 signature or M-of-N multi-signature of the message
 func (pub PublicKey) Verify(message, signature []byte) bool {
+	if pub.IsMulti() {
+		return pub.verifyMulti(message, signature)
+	}
 	return len(pub) == PublicKeySize &&
 		len(signature) == SignatureSize &&
 		ed25519.Verify([]byte(pub), message, signature)
 }
 
 func DecodePublicKey(s string) PublicKey {
+	if strings.HasPrefix(s, multiKeyEncodingPrefix) {
+		return decodeMultiPublicKey(s)
+	}
 	s = strings.TrimPrefix(s, publicKeyEncodingPrefix)
 	if p, _ := base64.StdEncoding.DecodeString(s); len(p) == PublicKeySize {
 		return p
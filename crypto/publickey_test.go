@@ -29,6 +29,24 @@ func TestDecodePublicKey_fail(t *testing.T) {
 	assert(t, pub == nil)
 }
 
+func TestDecodePrivateKey(t *testing.T) {
+	prv := NewPrivateKeyFromSeed("seed")
+
+	prv2 := DecodePrivateKey(prv.Encode())
+
+	assert(t, prv2 != nil)
+	assert(t, bytes.Equal(prv, prv2))
+	assert(t, DecodePrivateKey(prv.PublicKey().Encode()) == nil)
+	assert(t, DecodePrivateKey(prv.Encode()[:40]) == nil)
+}
+
+func TestDecodePrivateKey_fail(t *testing.T) {
+	prv := NewPrivateKeyFromSeed("seed")
+	prv[40]++ // public part does not match the seed
+
+	assert(t, DecodePrivateKey(prv.Encode()) == nil)
+}
+
 func TestPrivateKey_Sign(t *testing.T) {
 	prv := NewPrivateKeyFromSeed("seed")
 
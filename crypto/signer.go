@@ -0,0 +1,17 @@
+package crypto
+
+// Signer signs digests by the private key that is not necessarily kept in the process memory
+// (key agent, hardware token, air-gapped machine).
+type Signer interface {
+
+	// PublicKey returns public key of the signer
+	PublicKey() PublicKey
+
+	// SignDigest signs the digest (hash of the signed data)
+	SignDigest(digest []byte) ([]byte, error)
+}
+
+// SignDigest signs the digest by in-memory private key
+func (prv PrivateKey) SignDigest(digest []byte) ([]byte, error) {
+	return prv.Sign(digest), nil
+}
@@ -0,0 +1,394 @@
+// Package boltdb implements db.Storage in a single file of the embedded key-value engine bbolt.
+//
+// Values are split into chunks of chunkSize bytes, so large values are written and read by streams.
+// Every put value gets a new id:
+//
+//	bucket "keys":   key           -> id (8 bytes) | size (8 bytes)
+//	bucket "chunks": id | chunk-no -> chunk of the value
+//
+// bbolt keeps pages written by a read-write transaction in memory until the transaction is committed,
+// so put values are not written in the transaction of Execute. Chunks of a put value are written
+// under a new id by short transactions of batchChunks chunks, and the id is registered as staged:
+//
+//	bucket "staged": id -> (empty)
+//
+// When the function of Execute returns, the operations of the transaction are applied by one bbolt
+// transaction that swaps ids of the keys and deletes the replaced values (the commit point).
+// Staged values of the failed transaction are deleted; after a crash they are deleted by Open.
+//
+// A reader of a value reads its chunks by short read-only transactions;
+// if the value is replaced or deleted meanwhile, reading fails with ErrValueChanged.
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/denisskin/dweb/db"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	chunkSize   = 64 << 10 // 64 KiB
+	batchChunks = 16       // count of chunks written by one bbolt transaction
+)
+
+var (
+	bucketKeys   = []byte("keys")
+	bucketChunks = []byte("chunks")
+	bucketStaged = []byte("staged")
+)
+
+var (
+	ErrValueChanged = db.ErrValueChanged
+
+	errInvalidKey = errors.New("boltdb: invalid key")
+	errCorrupted  = errors.New("boltdb: corrupted value")
+)
+
+// DB is db.Storage in a bbolt database file
+type DB struct {
+	bdb  *bolt.DB
+	txMx sync.Mutex // serializes transactions
+}
+
+type boltTx struct {
+	db     *DB
+	ops    []txOp
+	staged []uint64 // ids of the values put by the transaction
+}
+
+// txOp is an operation of the transaction: put value id of size to the key,
+// delete the key (if id is 0) or delete keys with the prefix
+type txOp struct {
+	key    string
+	id     uint64
+	size   int64
+	prefix bool
+}
+
+// Open opens (or creates) the database file
+func Open(path string) (*DB, error) {
+	bdb, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketKeys, bucketChunks, bucketStaged} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return deleteStaged(tx, nil) // values staged by the transaction interrupted by a crash
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	return &DB{bdb: bdb}, nil
+}
+
+// Close closes the database file
+func (d *DB) Close() error {
+	return d.bdb.Close()
+}
+
+// Open returns reader of the value of the key; missing keys are read as empty values
+func (d *DB) Open(key string) (io.ReadSeekCloser, error) {
+	if key == "" {
+		return nil, errInvalidKey
+	}
+	r := &valueReader{db: d.bdb}
+	err := d.bdb.View(func(tx *bolt.Tx) (err error) {
+		r.id, r.size, err = getValueInfo(tx, key)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *DB) Exists(key string) (bool, error) {
+	_, err := d.Size(key)
+	if err == db.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *DB) Size(key string) (size int64, err error) {
+	if key == "" {
+		return 0, errInvalidKey
+	}
+	err = d.bdb.View(func(tx *bolt.Tx) error {
+		id, n, err := getValueInfo(tx, key)
+		if err == nil && id == 0 {
+			err = db.ErrNotFound
+		}
+		size = n
+		return err
+	})
+	return
+}
+
+func (d *DB) List(prefix string, fn func(key string) error) error {
+	var keys []string
+	err := d.bdb.View(func(tx *bolt.Tx) error {
+		keys = listKeys(tx, prefix)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) Execute(fn func(db.Transaction) error) (err error) {
+	d.txMx.Lock()
+	defer d.txMx.Unlock()
+
+	t := &boltTx{db: d}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+		if err != nil && len(t.staged) > 0 {
+			d.bdb.Update(func(tx *bolt.Tx) error {
+				return deleteStaged(tx, t.staged)
+			})
+		}
+	}()
+	if err = fn(t); err != nil {
+		return
+	}
+	return d.bdb.Update(t.apply)
+}
+
+// apply applies operations of the transaction
+func (t *boltTx) apply(tx *bolt.Tx) error {
+	keys, staged := tx.Bucket(bucketKeys), tx.Bucket(bucketStaged)
+	for _, o := range t.ops {
+		switch {
+		case o.prefix:
+			for _, key := range listKeys(tx, o.key) {
+				if err := deleteValue(tx, key); err != nil {
+					return err
+				}
+			}
+		case o.id == 0:
+			if err := deleteValue(tx, o.key); err != nil {
+				return err
+			}
+		default:
+			if err := deleteValue(tx, o.key); err != nil {
+				return err
+			}
+			info := make([]byte, 16)
+			binary.BigEndian.PutUint64(info, o.id)
+			binary.BigEndian.PutUint64(info[8:], uint64(o.size))
+			if err := keys.Put([]byte(o.key), info); err != nil {
+				return err
+			}
+			if err := staged.Delete(idKey(o.id)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Put writes chunks of the value by short bbolt transactions; the key refers to the value when the transaction is applied
+func (t *boltTx) Put(key string, value io.Reader) (err error) {
+	if key == "" {
+		return errInvalidKey
+	}
+	var id uint64
+	err = t.db.bdb.Update(func(tx *bolt.Tx) (err error) {
+		if id, err = tx.Bucket(bucketChunks).NextSequence(); err == nil {
+			err = tx.Bucket(bucketStaged).Put(idKey(id), nil)
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	t.staged = append(t.staged, id)
+
+	buf := make([]byte, batchChunks*chunkSize) // bbolt requires value to be valid for the life of the transaction
+	var size int64
+	for i, eof := uint32(0), false; !eof; {
+		n, err := io.ReadFull(value, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof = true
+		} else if err != nil {
+			return err
+		}
+		err = t.db.bdb.Update(func(tx *bolt.Tx) error {
+			chunks := tx.Bucket(bucketChunks)
+			for data := buf[:n]; len(data) > 0; i++ {
+				chunk := data
+				if len(chunk) > chunkSize {
+					chunk = chunk[:chunkSize]
+				}
+				if err := chunks.Put(chunkKey(id, i), chunk); err != nil {
+					return err
+				}
+				data = data[len(chunk):]
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		size += int64(n)
+	}
+	t.ops = append(t.ops, txOp{key: key, id: id, size: size})
+	return
+}
+
+func (t *boltTx) Delete(key string) error {
+	if key == "" {
+		return errInvalidKey
+	}
+	t.ops = append(t.ops, txOp{key: key})
+	return nil
+}
+
+func (t *boltTx) DeletePrefix(prefix string) error {
+	t.ops = append(t.ops, txOp{key: prefix, prefix: true})
+	return nil
+}
+
+// deleteValue deletes the key and chunks of its value
+func deleteValue(tx *bolt.Tx, key string) error {
+	id, size, err := getValueInfo(tx, key)
+	if err != nil || id == 0 {
+		return err
+	}
+	chunks := tx.Bucket(bucketChunks)
+	for i := uint32(0); int64(i)*chunkSize < size; i++ {
+		if err = chunks.Delete(chunkKey(id, i)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketKeys).Delete([]byte(key))
+}
+
+// deleteStaged deletes staged values of the ids (all staged values if ids is nil)
+func deleteStaged(tx *bolt.Tx, ids []uint64) error {
+	staged, chunks := tx.Bucket(bucketStaged), tx.Bucket(bucketChunks)
+	if ids == nil {
+		staged.ForEach(func(k, _ []byte) error {
+			ids = append(ids, binary.BigEndian.Uint64(k))
+			return nil
+		})
+	}
+	for _, id := range ids {
+		if staged.Get(idKey(id)) == nil { // applied
+			continue
+		}
+		prefix := idKey(id)
+		c := chunks.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		if err := staged.Delete(prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listKeys returns sorted keys with the prefix
+func listKeys(tx *bolt.Tx, prefix string) (keys []string) {
+	c := tx.Bucket(bucketKeys).Cursor()
+	for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+	return
+}
+
+func getValueInfo(tx *bolt.Tx, key string) (id uint64, size int64, err error) {
+	info := tx.Bucket(bucketKeys).Get([]byte(key))
+	if info == nil {
+		return
+	}
+	if len(info) != 16 {
+		return 0, 0, errCorrupted
+	}
+	return binary.BigEndian.Uint64(info), int64(binary.BigEndian.Uint64(info[8:])), nil
+}
+
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func chunkKey(id uint64, i uint32) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, id)
+	binary.BigEndian.PutUint32(key[8:], i)
+	return key
+}
+
+// valueReader reads the value by chunks
+type valueReader struct {
+	db   *bolt.DB
+	id   uint64
+	size int64
+	pos  int64
+}
+
+func (r *valueReader) Read(p []byte) (n int, err error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	i, offset := r.pos/chunkSize, r.pos%chunkSize
+	err = r.db.View(func(tx *bolt.Tx) error {
+		chunk := tx.Bucket(bucketChunks).Get(chunkKey(r.id, uint32(i)))
+		if chunk == nil {
+			return ErrValueChanged
+		}
+		if offset >= int64(len(chunk)) {
+			return errCorrupted
+		}
+		n = copy(p, chunk[offset:]) // chunk is valid only inside the transaction
+		return nil
+	})
+	r.pos += int64(n)
+	return
+}
+
+func (r *valueReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("boltdb: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("boltdb: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *valueReader) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package boltdb
+
+import (
+	"bytes"
+	"errors"
+	"github.com/denisskin/dweb/db"
+	"github.com/denisskin/dweb/db/dbtest"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T, path string) *DB {
+	d, err := Open(path)
+	dbtest.Assert(t, err == nil)
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+	d, err := Open(path)
+	dbtest.Assert(t, err == nil)
+
+	err = dbtest.Put(d, "/A/1.txt", "a1", ".headers", "[]", "/empty", "")
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, d, "/A/1.txt") == "a1")
+	dbtest.Assert(t, dbtest.Get(t, d, ".headers") == "[]")
+	dbtest.Assert(t, dbtest.Get(t, d, "/empty") == "")
+	dbtest.Assert(t, dbtest.Get(t, d, "/missing") == "")
+
+	err = dbtest.Put(d, "/A/1.txt", "new")
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, d, "/A/1.txt") == "new")
+
+	err = d.Execute(func(tx db.Transaction) error {
+		return tx.Delete(".headers")
+	})
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, d, ".headers") == "")
+
+	// value is replaced while reading
+	f, err := d.Open("/A/1.txt")
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Put(d, "/A/1.txt", "replaced") == nil)
+	_, err = io.ReadAll(f)
+	dbtest.Assert(t, err == ErrValueChanged)
+
+	// persisted
+	dbtest.Assert(t, d.Close() == nil)
+	d = openTestDB(t, path)
+	dbtest.Assert(t, dbtest.Get(t, d, "/A/1.txt") == "replaced")
+}
+
+func TestDB_storage(t *testing.T) {
+	dbtest.RunStorageTests(t, func(t *testing.T) db.Storage {
+		return openTestDB(t, filepath.Join(t.TempDir(), "sites.db"))
+	})
+}
+
+// countChunks returns count of stored chunks and staged values
+func countChunks(d *DB) (chunks, staged int) {
+	d.bdb.View(func(tx *bolt.Tx) error {
+		chunks, staged = tx.Bucket(bucketChunks).Stats().KeyN, tx.Bucket(bucketStaged).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+func TestDB_stagedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+	d := openTestDB(t, path)
+	data := bytes.Repeat([]byte("0123456789"), 400_000) // 4 MB; 62 chunks are written by 4 transactions
+	dbtest.Assert(t, dbtest.Put(d, "key", "old") == nil)
+
+	// values of the failed transaction are deleted
+	err := d.Execute(func(tx db.Transaction) error {
+		if err := tx.Put("key", bytes.NewReader(data)); err != nil {
+			return err
+		}
+		return errors.New("fail")
+	})
+	dbtest.Assert(t, err != nil)
+	dbtest.Assert(t, dbtest.Get(t, d, "key") == "old")
+	chunks, staged := countChunks(d)
+	dbtest.Assert(t, chunks == 1 && staged == 0)
+
+	// replaced values are deleted
+	err = d.Execute(func(tx db.Transaction) error {
+		tx.Put("key", bytes.NewReader(data))
+		tx.Delete("key")
+		return tx.Put("key", bytes.NewReader(data))
+	})
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, d, "key") == string(data))
+	chunks, staged = countChunks(d)
+	dbtest.Assert(t, chunks == 62 && staged == 0)
+
+	// values staged before a crash are deleted by Open
+	tx := &boltTx{db: d}
+	dbtest.Assert(t, tx.Put("key2", bytes.NewReader(data)) == nil)
+	dbtest.Assert(t, d.Close() == nil)
+	d = openTestDB(t, path)
+	dbtest.Assert(t, dbtest.Get(t, d, "key2") == "")
+	chunks, staged = countChunks(d)
+	dbtest.Assert(t, chunks == 62 && staged == 0)
+}
@@ -0,0 +1,434 @@
+// Package dbtest implements conformance tests of db.Storage implementations.
+//
+// A storage backend is tested by calling RunStorageTests from a test of the backend package:
+//
+//	func TestStorage(t *testing.T) {
+//		dbtest.RunStorageTests(t, func(t *testing.T) db.Storage {
+//			return mydb.New(t.TempDir())
+//		})
+//	}
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denisskin/dweb/db"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Factory returns new empty storage for the test
+type Factory func(t *testing.T) db.Storage
+
+var errTest = errors.New("dbtest: test error")
+
+// RunStorageTests runs the conformance tests of the storage made by factory; each test gets a new storage
+func RunStorageTests(t *testing.T, factory Factory) {
+	for _, test := range []struct {
+		name string
+		fn   func(*testing.T, db.Storage)
+	}{
+		{"MissingKey", testMissingKey},
+		{"PutDelete", testPutDelete},
+		{"Keys", testKeys},
+		{"LargeValue", testLargeValue},
+		{"Rollback", testRollback},
+		{"List", testList},
+		{"DeletePrefix", testDeletePrefix},
+		{"Sub", testSub},
+		{"Seek", testSeek},
+		{"Concurrency", testConcurrency},
+	} {
+		fn := test.fn
+		t.Run(test.name, func(t *testing.T) {
+			fn(t, factory(t))
+		})
+	}
+}
+
+// Assert fails the test if ok is false
+func Assert(t *testing.T, ok bool) {
+	if !ok {
+		t.Helper()
+		t.Fatal()
+	}
+}
+
+func assert(t *testing.T, ok bool, msg string, args ...any) {
+	if !ok {
+		t.Helper()
+		t.Fatalf(msg, args...)
+	}
+}
+
+func noErr(t *testing.T, err error) {
+	if err != nil {
+		t.Helper()
+		t.Fatal(err)
+	}
+}
+
+// Get returns the value of the key
+func Get(t *testing.T, s db.Storage, key string) string {
+	t.Helper()
+	r, err := s.Open(key)
+	noErr(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	noErr(t, err)
+	return string(data)
+}
+
+// Put puts values kv (key, value, key, value, ...) by one transaction
+func Put(s db.Storage, kv ...string) error {
+	return s.Execute(func(tx db.Transaction) error {
+		for i := 0; i < len(kv); i += 2 {
+			if err := tx.Put(kv[i], strings.NewReader(kv[i+1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func del(s db.Storage, keys ...string) error {
+	return s.Execute(func(tx db.Transaction) error {
+		for _, key := range keys {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func list(t *testing.T, s db.Storage, prefix string) string {
+	t.Helper()
+	var keys []string
+	noErr(t, s.List(prefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	return strings.Join(keys, ",")
+}
+
+func exists(t *testing.T, s db.Storage, key string) bool {
+	t.Helper()
+	ok, err := s.Exists(key)
+	noErr(t, err)
+	return ok
+}
+
+func testMissingKey(t *testing.T, s db.Storage) {
+	r, err := s.Open("missing")
+	assert(t, err == nil && r != nil, "Open of missing key: %v", err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	assert(t, err == nil && len(data) == 0, "missing key is not read as empty value")
+
+	assert(t, !exists(t, s, "missing"), "Exists of missing key")
+	_, err = s.Size("missing")
+	assert(t, err == db.ErrNotFound, "Size of missing key: %v", err)
+	assert(t, list(t, s, "") == "", "List of empty storage")
+	noErr(t, del(s, "missing"))
+}
+
+func testPutDelete(t *testing.T, s db.Storage) {
+	noErr(t, Put(s, "/A/1.txt", "a1", "/empty", ""))
+	assert(t, Get(t, s, "/A/1.txt") == "a1", "Put value")
+	assert(t, exists(t, s, "/empty"), "empty value does not exist")
+	size, err := s.Size("/A/1.txt")
+	assert(t, err == nil && size == 2, "Size: %d, %v", size, err)
+	size, err = s.Size("/empty")
+	assert(t, err == nil && size == 0, "Size of empty value: %d, %v", size, err)
+
+	noErr(t, Put(s, "/A/1.txt", "replaced"))
+	assert(t, Get(t, s, "/A/1.txt") == "replaced", "replaced value")
+
+	noErr(t, del(s, "/A/1.txt"))
+	assert(t, !exists(t, s, "/A/1.txt"), "deleted key exists")
+	assert(t, Get(t, s, "/A/1.txt") == "", "deleted key is not read as empty value")
+
+	// the last change of the key in the transaction wins
+	noErr(t, s.Execute(func(tx db.Transaction) error {
+		noErr(t, tx.Put("k", strings.NewReader("v1")))
+		noErr(t, tx.Delete("k"))
+		return tx.Put("k", strings.NewReader("v2"))
+	}))
+	assert(t, Get(t, s, "k") == "v2", "last put of the transaction")
+
+	// the stored key is deleted and put again by one transaction
+	noErr(t, s.Execute(func(tx db.Transaction) error {
+		noErr(t, tx.Delete("k"))
+		return tx.Put("k", strings.NewReader("v3"))
+	}))
+	assert(t, Get(t, s, "k") == "v3", "put after delete of the transaction")
+}
+
+func testKeys(t *testing.T, s db.Storage) {
+	keys := []string{".", ".headers", "/", "/A/", "/a/", "/A/1.txt", "UPPER", "upper", "k%2f", "k/", "~", "пути/файл", strings.Repeat("long/", 200)}
+	var kv []string
+	for i, key := range keys {
+		kv = append(kv, key, fmt.Sprint("value", i))
+	}
+	noErr(t, Put(s, kv...))
+	for i, key := range keys {
+		assert(t, Get(t, s, key) == fmt.Sprint("value", i), "value of key %q", key)
+	}
+	var listed []string
+	noErr(t, s.List("", func(key string) error {
+		listed = append(listed, key)
+		return nil
+	}))
+	assert(t, len(listed) == len(keys), "List: %d keys", len(listed))
+}
+
+// patternReader generates n bytes without buffering them
+type patternReader struct {
+	pos, n int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.pos >= r.n {
+		return 0, io.EOF
+	}
+	if rest := r.n - r.pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	for i := range p {
+		p[i] = patternByte(r.pos + int64(i))
+	}
+	r.pos += int64(len(p))
+	return len(p), nil
+}
+
+func patternByte(i int64) byte {
+	return byte(i*7 + i>>11)
+}
+
+func testLargeValue(t *testing.T, s db.Storage) {
+	const size = 5<<20 + 123
+	noErr(t, s.Execute(func(tx db.Transaction) error {
+		return tx.Put("large", &patternReader{n: size})
+	}))
+	n, err := s.Size("large")
+	assert(t, err == nil && n == size, "Size of large value: %d, %v", n, err)
+
+	r, err := s.Open("large")
+	noErr(t, err)
+	defer r.Close()
+	buf := make([]byte, 32<<10)
+	var pos int64
+	for {
+		n, err := r.Read(buf)
+		for i := 0; i < n; i++ {
+			if buf[i] != patternByte(pos+int64(i)) {
+				t.Fatalf("large value differs at %d", pos+int64(i))
+			}
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		noErr(t, err)
+	}
+	assert(t, pos == size, "read %d bytes of large value", pos)
+}
+
+func testRollback(t *testing.T, s db.Storage) {
+	noErr(t, Put(s, "k1", "v1", "k2", "v2"))
+
+	err := s.Execute(func(tx db.Transaction) error {
+		noErr(t, tx.Put("k1", strings.NewReader("changed")))
+		noErr(t, tx.Put("k3", strings.NewReader("new")))
+		noErr(t, tx.Delete("k2"))
+		return errTest
+	})
+	assert(t, errors.Is(err, errTest), "error of the transaction: %v", err)
+	assert(t, Get(t, s, "k1") == "v1", "put is not rolled back")
+	assert(t, Get(t, s, "k2") == "v2", "delete is not rolled back")
+	assert(t, !exists(t, s, "k3"), "new key is not rolled back")
+
+	err = s.Execute(func(tx db.Transaction) error {
+		noErr(t, tx.DeletePrefix(""))
+		panic(errTest)
+	})
+	assert(t, err != nil, "panic of the transaction is not returned as error")
+	assert(t, list(t, s, "") == "k1,k2", "keys after panic: %s", list(t, s, ""))
+
+	// failed reading of the value
+	err = s.Execute(func(tx db.Transaction) error {
+		return tx.Put("k1", io.MultiReader(strings.NewReader("partial"), errReader{}))
+	})
+	assert(t, err != nil, "error of the value reader is not returned")
+	assert(t, Get(t, s, "k1") == "v1", "partial value is written")
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errTest
+}
+
+func testList(t *testing.T, s db.Storage) {
+	noErr(t, Put(s, "b/2", "", "a/2", "", "a/1", "", "a", "", "ab", "", "c", ""))
+	assert(t, list(t, s, "") == "a,a/1,a/2,ab,b/2,c", "List: %s", list(t, s, ""))
+	assert(t, list(t, s, "a/") == "a/1,a/2", "List of prefix: %s", list(t, s, "a/"))
+	assert(t, list(t, s, "a") == "a,a/1,a/2,ab", "List of prefix: %s", list(t, s, "a"))
+	assert(t, list(t, s, "d") == "", "List of missing prefix")
+
+	// error of fn stops listing
+	n := 0
+	err := s.List("", func(string) error {
+		n++
+		return errTest
+	})
+	assert(t, err == errTest && n == 1, "List error: %v, %d calls", err, n)
+
+	// fn can use the storage
+	err = s.List("a/", func(key string) error {
+		if Get(t, s, key) != "" {
+			return errTest
+		}
+		return del(s, key)
+	})
+	noErr(t, err)
+	assert(t, list(t, s, "") == "a,ab,b/2,c", "List after deleting: %s", list(t, s, ""))
+}
+
+func testDeletePrefix(t *testing.T, s db.Storage) {
+	noErr(t, Put(s, "/A/1", "1", "/A/2/3", "3", "/AB", "ab", "/B", "b"))
+	noErr(t, s.Execute(func(tx db.Transaction) error {
+		noErr(t, tx.Put("/A/new", strings.NewReader("new")))
+		noErr(t, tx.DeletePrefix("/A/"))
+		return tx.Put("/A/4", strings.NewReader("4"))
+	}))
+	assert(t, list(t, s, "") == "/A/4,/AB,/B", "keys after DeletePrefix: %s", list(t, s, ""))
+}
+
+func testSub(t *testing.T, s db.Storage) {
+	s1, s2 := db.Sub(s, "site1/"), db.Sub(s, "site2/")
+	noErr(t, Put(s1, "/A/1.txt", "s1", ".headers", "h1"))
+	noErr(t, Put(s2, "/A/1.txt", "s2"))
+	noErr(t, Put(s, "site10", "x"))
+
+	assert(t, Get(t, s1, "/A/1.txt") == "s1", "value of sub-storage")
+	assert(t, Get(t, s2, "/A/1.txt") == "s2", "value of sub-storage")
+	assert(t, Get(t, s, "site1//A/1.txt") == "s1", "prefixed key of sub-storage")
+	assert(t, !exists(t, s2, ".headers"), "key of other sub-storage exists")
+	size, err := s1.Size(".headers")
+	assert(t, err == nil && size == 2, "Size in sub-storage: %d, %v", size, err)
+	assert(t, list(t, s1, "") == ".headers,/A/1.txt", "List of sub-storage: %s", list(t, s1, ""))
+	assert(t, list(t, s1, "/") == "/A/1.txt", "List of sub-storage: %s", list(t, s1, "/"))
+
+	noErr(t, s1.Execute(func(tx db.Transaction) error {
+		return tx.DeletePrefix("")
+	}))
+	assert(t, list(t, s1, "") == "", "sub-storage is not cleared")
+	assert(t, list(t, s, "") == "site10,site2//A/1.txt", "keys of storage: %s", list(t, s, ""))
+}
+
+func testSeek(t *testing.T, s db.Storage) {
+	noErr(t, Put(s, "k", "0123456789"))
+	r, err := s.Open("k")
+	noErr(t, err)
+	defer r.Close()
+
+	read := func(n int) string {
+		t.Helper()
+		buf := make([]byte, n)
+		n, err := io.ReadFull(r, buf)
+		if err != io.ErrUnexpectedEOF && err != io.EOF {
+			noErr(t, err)
+		}
+		return string(buf[:n])
+	}
+	seek := func(offset int64, whence int) int64 {
+		t.Helper()
+		pos, err := r.Seek(offset, whence)
+		noErr(t, err)
+		return pos
+	}
+	assert(t, read(3) == "012", "read")
+	assert(t, seek(0, io.SeekCurrent) == 3, "SeekCurrent position")
+	assert(t, seek(2, io.SeekCurrent) == 5 && read(2) == "56", "SeekCurrent")
+	assert(t, seek(1, io.SeekStart) == 1 && read(2) == "12", "SeekStart")
+	assert(t, seek(-3, io.SeekEnd) == 7 && read(10) == "789", "SeekEnd")
+	assert(t, seek(20, io.SeekStart) == 20 && read(1) == "", "read after the end")
+	assert(t, seek(0, io.SeekStart) == 0 && read(10) == "0123456789", "read after seek to start")
+	_, err = r.Seek(-1, io.SeekStart)
+	assert(t, err != nil, "seek to negative position")
+}
+
+// testConcurrency runs concurrent transactions and readers.
+// Readers must see whole committed values (or fail by db.ErrValueChanged).
+func testConcurrency(t *testing.T, s db.Storage) {
+	const writers, readers, iterations = 4, 4, 20
+	value := func(w, i int) string {
+		return strings.Repeat(fmt.Sprintf("[w%d:i%d]", w, i), 100+i)
+	}
+	isWhole := func(v string) bool {
+		if v == "" {
+			return true
+		}
+		var w, i int
+		_, err := fmt.Sscanf(v, "[w%d:i%d]", &w, &i)
+		return err == nil && v == value(w, i)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				v := value(w, i)
+				err := s.Execute(func(tx db.Transaction) error {
+					if err := tx.Put("shared", strings.NewReader(v)); err != nil {
+						return err
+					}
+					return tx.Put(fmt.Sprint("own/", w), strings.NewReader(v))
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				for _, key := range []string{"shared", "own/0"} {
+					f, err := s.Open(key)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					data, err := io.ReadAll(f)
+					f.Close()
+					if errors.Is(err, db.ErrValueChanged) { // the value is replaced while reading
+						continue
+					}
+					if err != nil || !isWhole(string(data)) {
+						t.Errorf("inconsistent value of %q: %v", key, err)
+						return
+					}
+				}
+				if err := s.List("own/", func(string) error { return nil }); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for w := 0; w < writers; w++ {
+		key := fmt.Sprint("own/", w)
+		assert(t, Get(t, s, key) == value(w, iterations-1), "last value of %q", key)
+	}
+	assert(t, isWhole(Get(t, s, "shared")) && Get(t, s, "shared") != "", "last value of shared key")
+}
@@ -0,0 +1,488 @@
+// Package filedb implements db.Storage persisted in a directory of the file system.
+//
+// Each key is stored in its own file under <dir>/data. The file name is the key escaped to lower-case
+// characters [a-z0-9_-] and %xx, so keys are mapped to files injectively even on case-insensitive
+// file systems. Long names are split into directories by maxNameLength characters (suffixed by '~').
+//
+// Transactions are atomic. Values written by the transaction are staged in <dir>/tmp. On commit the list of
+// operations is written to <dir>/journal (the commit point), then the operations are applied by renames
+// and the journal is removed. After a crash, Open replays the journal (if it was written) or discards
+// the staged files, so the storage contains either the old or the new state of the transaction.
+// If applying of the committed transaction fails, reads replay the journal before reading.
+//
+// Transactions of processes sharing the directory are serialized by the lock of the file <dir>/journal.lock.
+package filedb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/denisskin/dweb/db"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	dataDir     = "data"
+	tmpDir      = "tmp"
+	journalFile = "journal"
+	lockFile    = "journal.lock"
+
+	maxNameLength = 200
+	dirSuffix     = "~"
+)
+
+var errInvalidKey = errors.New("filedb: invalid key")
+
+type fileDB struct {
+	dir     string
+	txMx    sync.Mutex   // serializes transactions
+	mx      sync.RWMutex // locks data files while the transaction is applied
+	pending bool         // applying of the journal failed; it is replayed before reading (see rlock)
+}
+
+type fileTx struct {
+	db        *fileDB
+	ops       []op
+	committed bool // the journal of the transaction is written
+}
+
+// op is an operation of the transaction: put staged file Tmp to Key or delete Key (if Tmp is empty)
+type op struct {
+	Key string `json:"key"`
+	Tmp string `json:"tmp,omitempty"`
+}
+
+type emptyValue struct { // implements io.ReadSeekCloser for missing keys
+	*strings.Reader
+}
+
+func (emptyValue) Close() error {
+	return nil
+}
+
+// Open opens (or creates) the storage in the directory and recovers the interrupted transaction
+func Open(dir string) (db.Storage, error) {
+	s := &fileDB{dir: dir}
+	for _, d := range []string{dir, s.path(dataDir), s.path(tmpDir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rlock locks data files for reading. The journal of the committed transaction that failed to apply
+// is replayed first, so the state before the committed transaction is never read.
+func (s *fileDB) rlock() error {
+	for {
+		s.mx.RLock()
+		if !s.pending {
+			return nil
+		}
+		s.mx.RUnlock()
+		if err := s.replay(); err != nil {
+			return err
+		}
+	}
+}
+
+// replay recovers the interrupted transaction (see recover) out of transactions
+func (s *fileDB) replay() error {
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+
+	unlock, err := lockPath(s.path(lockFile))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.recover()
+}
+
+func (s *fileDB) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// keyPath returns path of the data file of the key
+func (s *fileDB) keyPath(key string) (string, error) {
+	if key == "" {
+		return "", errInvalidKey
+	}
+	name := escapeKey(key)
+	parts := []string{s.dir, dataDir}
+	for len(name) > maxNameLength {
+		parts = append(parts, name[:maxNameLength]+dirSuffix)
+		name = name[maxNameLength:]
+	}
+	return filepath.Join(append(parts, name)...), nil
+}
+
+// Open returns the value of the key as an open file; missing keys are read as empty values
+func (s *fileDB) Open(key string) (io.ReadSeekCloser, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.mx.RUnlock()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return emptyValue{strings.NewReader("")}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *fileDB) Exists(key string) (bool, error) {
+	_, err := s.Size(key)
+	if err == db.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *fileDB) Size(key string) (int64, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return 0, err
+	}
+	if err = s.rlock(); err != nil {
+		return 0, err
+	}
+	defer s.mx.RUnlock()
+
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) || err == nil && st.IsDir() {
+		return 0, db.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (s *fileDB) List(prefix string, fn func(key string) error) error {
+	keys, err := s.keys(prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys returns sorted keys with the prefix
+func (s *fileDB) keys(prefix string) (keys []string, err error) {
+	if err = s.rlock(); err != nil {
+		return
+	}
+	defer s.mx.RUnlock()
+
+	escPrefix := escapeKey(prefix)
+	var walk func(dir, name string) error
+	walk = func(dir, name string) error {
+		ff, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range ff {
+			fName := name + strings.TrimSuffix(f.Name(), dirSuffix)
+			if !strings.HasPrefix(fName, escPrefix) && !strings.HasPrefix(escPrefix, fName) {
+				continue
+			}
+			if f.IsDir() {
+				if err = walk(filepath.Join(dir, f.Name()), fName); err != nil {
+					return err
+				}
+			} else if key, err := unescapeKey(fName); err == nil && strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	}
+	if err = walk(s.path(dataDir), ""); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (s *fileDB) Execute(fn func(db.Transaction) error) (err error) {
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+
+	unlock, err := lockPath(s.path(lockFile))
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if _, errStat := os.Stat(s.path(journalFile)); errStat == nil { // applying of the previous transaction failed
+		if err = s.recover(); err != nil {
+			return
+		}
+	}
+	tx := &fileTx{db: s}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+		if err != nil && !tx.committed {
+			tx.discard()
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return
+	}
+	return tx.commit()
+}
+
+func (t *fileTx) Put(key string, value io.Reader) (err error) {
+	if _, err = t.db.keyPath(key); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(t.db.path(tmpDir), "put-")
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(f, value); err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	t.ops = append(t.ops, op{Key: key, Tmp: filepath.Base(f.Name())})
+	return
+}
+
+func (t *fileTx) Delete(key string) error {
+	if _, err := t.db.keyPath(key); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, op{Key: key})
+	return nil
+}
+
+func (t *fileTx) DeletePrefix(prefix string) error {
+	keys, err := t.db.keys(prefix)
+	if err != nil {
+		return err
+	}
+	for _, o := range t.ops { // keys put by the transaction
+		if o.Tmp != "" && strings.HasPrefix(o.Key, prefix) {
+			keys = append(keys, o.Key)
+		}
+	}
+	for _, key := range keys {
+		t.ops = append(t.ops, op{Key: key})
+	}
+	return nil
+}
+
+// discard removes staged files of the failed transaction
+func (t *fileTx) discard() {
+	for _, o := range t.ops {
+		if o.Tmp != "" {
+			os.Remove(filepath.Join(t.db.path(tmpDir), o.Tmp))
+		}
+	}
+}
+
+// collapse leaves the last operation of each key and removes staged files of the overwritten values,
+// so replaying of the journal doesn't depend on the order of operations
+func (t *fileTx) collapse() {
+	seen := map[string]bool{}
+	ops := make([]op, 0, len(t.ops))
+	for i := len(t.ops) - 1; i >= 0; i-- {
+		if o := t.ops[i]; !seen[o.Key] {
+			seen[o.Key] = true
+			ops = append(ops, o)
+		} else if o.Tmp != "" {
+			os.Remove(filepath.Join(t.db.path(tmpDir), o.Tmp))
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 { // reverse
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	t.ops = ops
+}
+
+// commit writes the journal of the transaction and applies it.
+// Once the journal is written the transaction is committed: if applying fails again after retry,
+// the journal is replayed by the next read, the next transaction or Open.
+func (t *fileTx) commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+	t.collapse()
+	s := t.db
+	data, err := json.Marshal(t.ops)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(journalFile + ".tmp")
+	if err = writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path(journalFile)); err != nil { // commit point
+		return err
+	}
+	t.committed = true
+	syncDir(s.dir)
+	if err = s.apply(t.ops); err != nil {
+		s.apply(t.ops) // retry; applying is idempotent
+	}
+	return nil
+}
+
+// apply applies operations of the committed transaction and removes the journal.
+// Applying is idempotent, so an interrupted transaction is replayed from the start.
+func (s *fileDB) apply(ops []op) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	err = s.applyOps(ops)
+	s.pending = err != nil
+	return
+}
+
+func (s *fileDB) applyOps(ops []op) error {
+	dirs := map[string]bool{}
+	for _, o := range ops {
+		path, err := s.keyPath(o.Key)
+		if err != nil {
+			return err
+		}
+		if o.Tmp == "" {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		tmp := filepath.Join(s.path(tmpDir), o.Tmp)
+		if _, err = os.Stat(tmp); os.IsNotExist(err) { // renamed before the crash
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err = os.Rename(tmp, path); err != nil {
+			return err
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return os.Remove(s.path(journalFile))
+}
+
+// recover replays the committed transaction and removes files staged by the interrupted one
+func (s *fileDB) recover() error {
+	data, err := os.ReadFile(s.path(journalFile))
+	if err == nil {
+		var ops []op
+		if err = json.Unmarshal(data, &ops); err != nil {
+			return fmt.Errorf("filedb: invalid journal: %w", err)
+		}
+		if err = s.apply(ops); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(s.path(journalFile + ".tmp"))
+	ff, err := os.ReadDir(s.path(tmpDir))
+	if err != nil {
+		return err
+	}
+	for _, f := range ff {
+		if err = os.Remove(filepath.Join(s.path(tmpDir), f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// unescapeKey decodes the key escaped by escapeKey
+func unescapeKey(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c != '%' {
+			b.WriteByte(c)
+		} else if i+2 < len(name) {
+			v, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", errInvalidKey
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		} else {
+			return "", errInvalidKey
+		}
+	}
+	return b.String(), nil
+}
+
+// escapeKey escapes the key to the file name of characters [a-z0-9_-] and %xx
+func escapeKey(key string) string {
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
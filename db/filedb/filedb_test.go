@@ -0,0 +1,144 @@
+package filedb
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/denisskin/dweb/db"
+	"github.com/denisskin/dweb/db/dbtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tmpFiles(dir string) int {
+	ff, _ := os.ReadDir(filepath.Join(dir, tmpDir))
+	return len(ff)
+}
+
+func TestFileDB(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	dbtest.Assert(t, err == nil)
+
+	err = dbtest.Put(s, "/A/1.txt", "a1", "/a/1.txt", "lower", ".headers", "[]")
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/1.txt") == "a1")
+	dbtest.Assert(t, dbtest.Get(t, s, "/a/1.txt") == "lower")
+	dbtest.Assert(t, dbtest.Get(t, s, ".headers") == "[]")
+	dbtest.Assert(t, dbtest.Get(t, s, "/missing") == "")
+
+	err = s.Execute(func(tx db.Transaction) error {
+		return tx.Delete("/A/1.txt")
+	})
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/1.txt") == "")
+
+	// staged files of the failed transaction are removed
+	err = s.Execute(func(tx db.Transaction) error {
+		tx.Put("/a/1.txt", strings.NewReader("new"))
+		return errors.New("fail")
+	})
+	dbtest.Assert(t, err != nil)
+	dbtest.Assert(t, tmpFiles(dir) == 0)
+
+	// persisted
+	s, err = Open(dir)
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "/a/1.txt") == "lower")
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/1.txt") == "")
+}
+
+func TestFileDB_storage(t *testing.T) {
+	dbtest.RunStorageTests(t, func(t *testing.T) db.Storage {
+		s, err := Open(t.TempDir())
+		dbtest.Assert(t, err == nil)
+		return s
+	})
+}
+
+func TestFileDB_longKeys(t *testing.T) {
+	s, _ := Open(t.TempDir())
+	k1 := strings.Repeat("k", maxNameLength)
+	k2 := strings.Repeat("k", 3*maxNameLength+1)
+
+	err := dbtest.Put(s, k1, "v1", k2, "v2")
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, k1) == "v1")
+	dbtest.Assert(t, dbtest.Get(t, s, k2) == "v2")
+
+	err = dbtest.Put(s, "", "v")
+	dbtest.Assert(t, err == errInvalidKey)
+}
+
+func TestFileDB_recover(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir)
+	dbtest.Assert(t, dbtest.Put(s, "key1", "old", "key2", "old") == nil)
+
+	// crash after the commit point: journal is replayed
+	stage := func(name, value string) {
+		dbtest.Assert(t, os.WriteFile(filepath.Join(dir, tmpDir, name), []byte(value), 0644) == nil)
+	}
+	stage("put-1", "new")
+	dbtest.Assert(t, os.WriteFile(filepath.Join(dir, journalFile), []byte(`[{"key":"key1","tmp":"put-1"},{"key":"key2"}]`), 0644) == nil)
+	s, err := Open(dir)
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "key1") == "new")
+	dbtest.Assert(t, dbtest.Get(t, s, "key2") == "")
+
+	// crash before the commit point: staged files are discarded
+	stage("put-2", "new2")
+	dbtest.Assert(t, os.WriteFile(filepath.Join(dir, journalFile+".tmp"), []byte(`[{"key":"key1","tmp":"put-2"}]`), 0644) == nil)
+	s, err = Open(dir)
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "key1") == "new")
+	dbtest.Assert(t, tmpFiles(dir) == 0)
+}
+
+func TestFileDB_recover_deleteThenPut(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir)
+	dbtest.Assert(t, dbtest.Put(s, "/A/1.txt", "old", "/A/2.txt", "old") == nil)
+
+	tx := &fileTx{db: s.(*fileDB)}
+	dbtest.Assert(t, tx.Delete("/A/1.txt") == nil)
+	dbtest.Assert(t, tx.Put("/A/1.txt", strings.NewReader("new1")) == nil)
+	dbtest.Assert(t, tx.DeletePrefix("/A/") == nil)
+	dbtest.Assert(t, tx.Put("/A/2.txt", strings.NewReader("new2")) == nil)
+	dbtest.Assert(t, tx.commit() == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/1.txt") == "")
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/2.txt") == "new2")
+
+	// crash after the renames before the journal is removed: replay gives the same state
+	journal, _ := json.Marshal(tx.ops)
+	dbtest.Assert(t, os.WriteFile(filepath.Join(dir, journalFile), journal, 0644) == nil)
+	s, err := Open(dir)
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/1.txt") == "")
+	dbtest.Assert(t, dbtest.Get(t, s, "/A/2.txt") == "new2")
+	dbtest.Assert(t, tmpFiles(dir) == 0)
+}
+
+func TestFileDB_recover_failedApply(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir)
+	dbtest.Assert(t, dbtest.Put(s, "key1", "old") == nil)
+
+	// the data file of the key can not be replaced: applying of the committed transaction fails
+	path, _ := s.(*fileDB).keyPath("key0")
+	dbtest.Assert(t, os.MkdirAll(filepath.Join(path, "x"), 0755) == nil)
+	err := dbtest.Put(s, "key0", "new", "key1", "new")
+	dbtest.Assert(t, err == nil)
+
+	// reads replay the journal and do not see the state before the transaction
+	_, err = s.Open("key1")
+	dbtest.Assert(t, err != nil)
+	_, err = s.Exists("key1")
+	dbtest.Assert(t, err != nil)
+
+	dbtest.Assert(t, os.RemoveAll(path) == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "key1") == "new")
+	dbtest.Assert(t, dbtest.Get(t, s, "key0") == "new")
+	dbtest.Assert(t, tmpFiles(dir) == 0)
+}
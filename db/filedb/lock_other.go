@@ -0,0 +1,14 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package filedb
+
+import "os"
+
+// lockPath creates the lock file; processes are not serialized on this platform
+func lockPath(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
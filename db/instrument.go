@@ -0,0 +1,280 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// operations of the storage reported to Metrics
+const (
+	OpOpen         = "open"
+	OpRead         = "read" // reading of the opened value (reported on Close; values that are not closed are not reported)
+	OpExists       = "exists"
+	OpSize         = "size"
+	OpList         = "list"
+	OpExecute      = "execute"
+	OpPut          = "put"
+	OpDelete       = "delete"
+	OpDeletePrefix = "delete_prefix"
+)
+
+// Event is a measurement of the storage operation
+type Event struct {
+	Op       string        // operation (OpOpen, OpPut, ...)
+	Key      string        // key of the operation (prefix for OpList and OpDeletePrefix; empty for OpExecute)
+	Prefix   string        // the longest of the instrumented prefixes of the key
+	Bytes    int64         // bytes read or written
+	Duration time.Duration // duration of the operation (for OpRead: from Open to Close of the value, including idle time)
+	Err      error         // error of the operation
+}
+
+// Metrics receives measurements of operations of the instrumented storage
+type Metrics interface {
+	Observe(e Event)
+}
+
+// MetricsFunc is a callback that implements Metrics
+type MetricsFunc func(e Event)
+
+func (fn MetricsFunc) Observe(e Event) {
+	fn(e)
+}
+
+// Instrument returns the storage that reports its operations to m.
+// Operations are grouped by the longest of prefixes the key starts with (or by empty prefix).
+func Instrument(db Storage, m Metrics, prefixes ...string) Storage {
+	pp := append([]string(nil), prefixes...)
+	sort.Slice(pp, func(i, j int) bool { return len(pp[i]) > len(pp[j]) })
+	return &instrumentedStorage{db, m, pp}
+}
+
+type instrumentedStorage struct {
+	db       Storage
+	m        Metrics
+	prefixes []string // sorted by length desc
+}
+
+type instrumentedTx struct {
+	tx Transaction
+	d  *instrumentedStorage
+}
+
+type instrumentedValue struct {
+	io.ReadSeekCloser
+	d     *instrumentedStorage
+	key   string
+	start time.Time
+	n     int64
+	err   error
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (d *instrumentedStorage) prefix(key string) string {
+	for _, p := range d.prefixes {
+		if strings.HasPrefix(key, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func (d *instrumentedStorage) observe(op, key string, start time.Time, n int64, err error) {
+	d.m.Observe(Event{
+		Op:       op,
+		Key:      key,
+		Prefix:   d.prefix(key),
+		Bytes:    n,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+}
+
+func (d *instrumentedStorage) Open(key string) (io.ReadSeekCloser, error) {
+	start := time.Now()
+	r, err := d.db.Open(key)
+	d.observe(OpOpen, key, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedValue{ReadSeekCloser: r, d: d, key: key, start: start}, nil
+}
+
+func (d *instrumentedStorage) Exists(key string) (ok bool, err error) {
+	start := time.Now()
+	ok, err = d.db.Exists(key)
+	d.observe(OpExists, key, start, 0, err)
+	return
+}
+
+func (d *instrumentedStorage) Size(key string) (size int64, err error) {
+	start := time.Now()
+	size, err = d.db.Size(key)
+	if err == ErrNotFound { // is not a failure of the storage
+		d.observe(OpSize, key, start, 0, nil)
+	} else {
+		d.observe(OpSize, key, start, 0, err)
+	}
+	return
+}
+
+func (d *instrumentedStorage) List(prefix string, fn func(key string) error) (err error) {
+	start := time.Now()
+	err = d.db.List(prefix, fn)
+	d.observe(OpList, prefix, start, 0, err)
+	return
+}
+
+func (d *instrumentedStorage) Execute(fn func(tx Transaction) error) (err error) {
+	start := time.Now()
+	err = d.db.Execute(func(tx Transaction) error {
+		return fn(&instrumentedTx{tx, d})
+	})
+	d.observe(OpExecute, "", start, 0, err)
+	return
+}
+
+func (t *instrumentedTx) Put(key string, value io.Reader) (err error) {
+	start := time.Now()
+	r := &countingReader{r: value}
+	err = t.tx.Put(key, r)
+	t.d.observe(OpPut, key, start, r.n, err)
+	return
+}
+
+func (t *instrumentedTx) Delete(key string) (err error) {
+	start := time.Now()
+	err = t.tx.Delete(key)
+	t.d.observe(OpDelete, key, start, 0, err)
+	return
+}
+
+func (t *instrumentedTx) DeletePrefix(prefix string) (err error) {
+	start := time.Now()
+	err = t.tx.DeletePrefix(prefix)
+	t.d.observe(OpDeletePrefix, prefix, start, 0, err)
+	return
+}
+
+func (v *instrumentedValue) Read(p []byte) (n int, err error) {
+	n, err = v.ReadSeekCloser.Read(p)
+	v.n += int64(n)
+	if err != nil && err != io.EOF && v.err == nil {
+		v.err = err
+	}
+	return
+}
+
+func (v *instrumentedValue) Close() error {
+	err := v.ReadSeekCloser.Close()
+	if v.err == nil {
+		v.err = err
+	}
+	v.d.observe(OpRead, v.key, v.start, v.n, v.err)
+	return err
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += int64(n)
+	return
+}
+
+//------------------------------------------------------------
+
+// Registry is Metrics that accumulates statistics of operations by prefixes
+type Registry struct {
+	mx    sync.Mutex
+	stats map[statKey]*Stat
+}
+
+// Stat is statistics of the operation on keys with the prefix
+type Stat struct {
+	Count    int64         // count of operations
+	Errors   int64         // count of failed operations
+	Bytes    int64         // bytes read or written
+	Duration time.Duration // total duration of operations
+}
+
+type statKey struct {
+	Op, Prefix string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{stats: map[statKey]*Stat{}}
+}
+
+func (r *Registry) Observe(e Event) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	s := r.stats[statKey{e.Op, e.Prefix}]
+	if s == nil {
+		s = &Stat{}
+		r.stats[statKey{e.Op, e.Prefix}] = s
+	}
+	s.Count++
+	if e.Err != nil {
+		s.Errors++
+	}
+	s.Bytes += e.Bytes
+	s.Duration += e.Duration
+}
+
+// Stat returns statistics of the operation on keys with the prefix
+func (r *Registry) Stat(op, prefix string) Stat {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if s := r.stats[statKey{op, prefix}]; s != nil {
+		return *s
+	}
+	return Stat{}
+}
+
+// WritePrometheus writes the statistics in Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mx.Lock()
+	keys := make([]statKey, 0, len(r.stats))
+	stats := make(map[statKey]Stat, len(r.stats))
+	for k, s := range r.stats {
+		keys = append(keys, k)
+		stats[k] = *s
+	}
+	r.mx.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Op != keys[j].Op {
+			return keys[i].Op < keys[j].Op
+		}
+		return keys[i].Prefix < keys[j].Prefix
+	})
+	var b strings.Builder
+	for _, m := range []struct {
+		name, typ, help string
+		value           func(Stat) string
+	}{
+		{"dweb_db_operations_total", "counter", "Count of storage operations.", func(s Stat) string { return fmt.Sprint(s.Count) }},
+		{"dweb_db_errors_total", "counter", "Count of failed storage operations.", func(s Stat) string { return fmt.Sprint(s.Errors) }},
+		{"dweb_db_bytes_total", "counter", "Bytes read (op=\"read\") or written (op=\"put\").", func(s Stat) string { return fmt.Sprint(s.Bytes) }},
+		{"dweb_db_duration_seconds_total", "counter", "Total duration of storage operations.", func(s Stat) string { return fmt.Sprint(s.Duration.Seconds()) }},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s{op=%s,prefix=%s} %s\n", m.name, promLabel(k.Op), promLabel(k.Prefix), m.value(stats[k]))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func promLabel(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,73 @@
+package db_test
+
+import (
+	"errors"
+	"github.com/denisskin/dweb/db"
+	"github.com/denisskin/dweb/db/dbtest"
+	"github.com/denisskin/dweb/db/memdb"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	reg := db.NewRegistry()
+	var events []db.Event
+	s := db.Instrument(memdb.New(), reg, "/", ".blobs/")
+	traced := db.Instrument(s, db.MetricsFunc(func(e db.Event) {
+		events = append(events, e)
+	}))
+
+	err := traced.Execute(func(tx db.Transaction) error {
+		if err := tx.Put("/A/1.txt", strings.NewReader("hello")); err != nil {
+			return err
+		}
+		return tx.Put(".blobs/01", strings.NewReader("blob"))
+	})
+	dbtest.Assert(t, err == nil)
+
+	errFail := errors.New("fail")
+	err = s.Execute(func(tx db.Transaction) error {
+		tx.Delete("/A/1.txt")
+		return errFail
+	})
+	dbtest.Assert(t, err == errFail)
+
+	r, err := s.Open("/A/1.txt")
+	dbtest.Assert(t, err == nil)
+	data, _ := io.ReadAll(r)
+	dbtest.Assert(t, string(data) == "hello")
+	r.Close()
+	_, err = s.Size(".headers")
+	dbtest.Assert(t, err == db.ErrNotFound)
+
+	dbtest.Assert(t, reg.Stat(db.OpPut, "/") == db.Stat{Count: 1, Bytes: 5, Duration: reg.Stat(db.OpPut, "/").Duration})
+	dbtest.Assert(t, reg.Stat(db.OpPut, ".blobs/").Bytes == 4)
+	dbtest.Assert(t, reg.Stat(db.OpDelete, "/").Count == 1)
+	dbtest.Assert(t, reg.Stat(db.OpExecute, "").Count == 2)
+	dbtest.Assert(t, reg.Stat(db.OpExecute, "").Errors == 1)
+	dbtest.Assert(t, reg.Stat(db.OpRead, "/").Bytes == 5)
+	dbtest.Assert(t, reg.Stat(db.OpSize, "").Count == 1)
+	dbtest.Assert(t, reg.Stat(db.OpSize, "").Errors == 0)
+
+	// callback gets events of the outer storage
+	dbtest.Assert(t, len(events) == 3)
+	dbtest.Assert(t, events[0].Op == db.OpPut && events[0].Key == "/A/1.txt" && events[0].Bytes == 5)
+	dbtest.Assert(t, events[2].Op == db.OpExecute && events[2].Err == nil)
+
+	var b strings.Builder
+	err = reg.WritePrometheus(&b)
+	dbtest.Assert(t, err == nil)
+	out := b.String()
+	dbtest.Assert(t, strings.Contains(out, "# TYPE dweb_db_operations_total counter\n"))
+	dbtest.Assert(t, strings.Contains(out, `dweb_db_operations_total{op="execute",prefix=""} 2`+"\n"))
+	dbtest.Assert(t, strings.Contains(out, `dweb_db_errors_total{op="execute",prefix=""} 1`+"\n"))
+	dbtest.Assert(t, strings.Contains(out, `dweb_db_bytes_total{op="put",prefix=".blobs/"} 4`+"\n"))
+	dbtest.Assert(t, strings.Contains(out, `dweb_db_bytes_total{op="read",prefix="/"} 5`+"\n"))
+}
+
+func TestInstrument_storage(t *testing.T) {
+	dbtest.RunStorageTests(t, func(*testing.T) db.Storage {
+		return db.Instrument(memdb.New(), db.NewRegistry(), "/")
+	})
+}
@@ -5,12 +5,24 @@ import (
 	"fmt"
 	"github.com/denisskin/dweb/db"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 )
 
-type memDB map[string][]byte
+// memDB is db.Storage in memory.
+// Stored values are never modified, so opened values are snapshots that are not changed by later transactions.
+type memDB struct {
+	txMx sync.Mutex   // serializes transactions
+	mx   sync.RWMutex // locks data while the transaction is applied
+	data map[string][]byte
+}
 
-type memTx memDB
+// memTx buffers changes of the transaction until it is applied
+type memTx struct {
+	db      *memDB
+	changes map[string][]byte // new values of the keys (nil for deleted keys)
+}
 
 type memValue struct { // implements io.ReadSeekCloser
 	*bytes.Reader
@@ -20,35 +32,111 @@ func (v memValue) Close() error {
 	return nil
 }
 
-func (s memDB) Get(key string) ([]byte, error) {
-	return s[key], nil
+func New() db.Storage {
+	return &memDB{data: map[string][]byte{}}
+}
+
+func (s *memDB) Get(key string) ([]byte, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.data[key], nil
 }
 
-func (s memDB) Open(key string) (io.ReadSeekCloser, error) {
-	return memValue{bytes.NewReader(s[key])}, nil
+func (s *memDB) Open(key string) (io.ReadSeekCloser, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return memValue{bytes.NewReader(s.data[key])}, nil
 }
 
-var memDBMx sync.Mutex
+func (s *memDB) Exists(key string) (bool, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, ok := s.data[key]
+	return ok, nil
+}
 
-func (s memDB) Execute(fn func(db.Transaction) error) (err error) {
-	defer recoverErr(&err)
-	memDBMx.Lock()
-	defer memDBMx.Unlock()
-	return fn(memTx(s))
+func (s *memDB) Size(key string) (int64, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	v, ok := s.data[key]
+	if !ok {
+		return 0, db.ErrNotFound
+	}
+	return int64(len(v)), nil
 }
 
-func (s memTx) Put(key string, value io.Reader) (err error) {
-	s[key], err = io.ReadAll(value)
+func (s *memDB) List(prefix string, fn func(key string) error) error {
+	for _, key := range s.keys(prefix) {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys returns sorted keys with the prefix
+func (s *memDB) keys(prefix string) (keys []string) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
 	return
 }
 
-func (s memTx) Delete(key string) error {
-	delete(s, key)
+// Execute executes fn in the transaction. Changes are applied after fn returns;
+// reads of the storage made by fn return the values as they were before the transaction.
+func (s *memDB) Execute(fn func(db.Transaction) error) (err error) {
+	defer recoverErr(&err)
+	s.txMx.Lock()
+	defer s.txMx.Unlock()
+
+	tx := &memTx{db: s, changes: map[string][]byte{}}
+	if err = fn(tx); err != nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for key, v := range tx.changes {
+		if v != nil {
+			s.data[key] = v
+		} else {
+			delete(s.data, key)
+		}
+	}
+	return
+}
+
+func (t *memTx) Put(key string, value io.Reader) error {
+	v, err := io.ReadAll(value)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	t.changes[key] = v
 	return nil
 }
 
-func New() db.Storage {
-	return &memDB{}
+func (t *memTx) Delete(key string) error {
+	t.changes[key] = nil
+	return nil
+}
+
+func (t *memTx) DeletePrefix(prefix string) error {
+	for _, key := range t.db.keys(prefix) {
+		t.changes[key] = nil
+	}
+	for key := range t.changes {
+		if strings.HasPrefix(key, prefix) {
+			t.changes[key] = nil
+		}
+	}
+	return nil
 }
 
 func recoverErr(err *error) {
@@ -0,0 +1,57 @@
+package memdb
+
+import (
+	"errors"
+	"github.com/denisskin/dweb/db"
+	"github.com/denisskin/dweb/db/dbtest"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemDB(t *testing.T) {
+	dbtest.RunStorageTests(t, func(*testing.T) db.Storage {
+		return New()
+	})
+}
+
+func TestMemDB_snapshot(t *testing.T) {
+	s := New()
+	err := s.Execute(func(tx db.Transaction) error {
+		return tx.Put("key", strings.NewReader("v1"))
+	})
+	dbtest.Assert(t, err == nil)
+
+	r, _ := s.Open("key")
+	err = s.Execute(func(tx db.Transaction) error {
+		if err := tx.Put("key", strings.NewReader("v2")); err != nil {
+			return err
+		}
+		if err := tx.Put("new", strings.NewReader("")); err != nil {
+			return err
+		}
+		if dbtest.Get(t, s, "key") != "v1" { // reads of the transaction see the previous state
+			return errors.New("not isolated")
+		}
+		return nil
+	})
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s, "key") == "v2")
+	ok, _ := s.Exists("new")
+	dbtest.Assert(t, ok)
+
+	data, _ := io.ReadAll(r) // opened before the transaction
+	dbtest.Assert(t, string(data) == "v1")
+}
+
+func TestMemDB_instances(t *testing.T) {
+	s1, s2 := New(), New()
+	err := s1.Execute(func(db.Transaction) error {
+		return s2.Execute(func(tx db.Transaction) error { // transactions of instances are not serialized
+			return tx.Put("key", strings.NewReader("v"))
+		})
+	})
+	dbtest.Assert(t, err == nil)
+	dbtest.Assert(t, dbtest.Get(t, s2, "key") == "v")
+	dbtest.Assert(t, dbtest.Get(t, s1, "key") == "")
+}
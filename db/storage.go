@@ -3,17 +3,44 @@ package db
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 )
 
+var (
+	ErrNotFound = errors.New("db: key not found")
+
+	// ErrValueChanged is returned by reader of the value if the value is replaced or deleted while it is read
+	// (storages that do not keep opened values unchanged)
+	ErrValueChanged = errors.New("db: value is changed")
+)
+
 type Storage interface {
+
+	// Open opens the value of the key (missing keys are read as empty values)
 	Open(key string) (io.ReadSeekCloser, error)
+
+	// Exists says the key exists
+	Exists(key string) (bool, error)
+
+	// Size returns size of the value of the key (ErrNotFound if the key does not exist)
+	Size(key string) (int64, error)
+
+	// List calls fn for the keys with the prefix in ascending order.
+	// Keys are listed as they are at the start of the call; fn can use the storage.
+	// Listing stops if fn returns an error; the error is returned by List.
+	List(prefix string, fn func(key string) error) error
+
+	// Execute executes fn in the transaction; all changes of the transaction are discarded if fn returns an error
 	Execute(func(tx Transaction) error) error
 }
 
 type Transaction interface {
 	Put(key string, value io.Reader) error
 	Delete(key string) error
+
+	// DeletePrefix deletes all keys with the prefix
+	DeletePrefix(prefix string) error
 }
 
 func GetJSON(db Storage, key string, v interface{}) (err error) {
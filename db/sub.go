@@ -1,6 +1,9 @@
 package db
 
-import "io"
+import (
+	"io"
+	"strings"
+)
 
 func Sub(db Storage, prefix string) Storage {
 	return &subStorage{prefix, db}
@@ -17,7 +20,21 @@ type subTransaction struct {
 }
 
 func (d *subStorage) Open(key string) (io.ReadSeekCloser, error) {
-	return d.Open(d.prefix + key)
+	return d.db.Open(d.prefix + key)
+}
+
+func (d *subStorage) Exists(key string) (bool, error) {
+	return d.db.Exists(d.prefix + key)
+}
+
+func (d *subStorage) Size(key string) (int64, error) {
+	return d.db.Size(d.prefix + key)
+}
+
+func (d *subStorage) List(prefix string, fn func(key string) error) error {
+	return d.db.List(d.prefix+prefix, func(key string) error {
+		return fn(strings.TrimPrefix(key, d.prefix))
+	})
 }
 
 func (d *subStorage) Execute(fn func(tx Transaction) error) error {
@@ -33,3 +50,7 @@ func (t *subTransaction) Put(key string, value io.Reader) error {
 func (t *subTransaction) Delete(key string) error {
 	return t.tx.Delete(t.prefix + key)
 }
+
+func (t *subTransaction) DeletePrefix(prefix string) error {
+	return t.tx.DeletePrefix(t.prefix + prefix)
+}
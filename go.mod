@@ -1,3 +1,15 @@
 module github.com/denisskin/dweb
 
 go 1.18
+
+require (
+	filippo.io/edwards25519 v1.0.0
+	github.com/zeebo/blake3 v0.2.4
+	go.etcd.io/bbolt v1.3.9
+	golang.org/x/crypto v0.21.0
+)
+
+require (
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+)
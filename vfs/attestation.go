@@ -0,0 +1,133 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db"
+	"time"
+)
+
+// MaxAttestations is the max count of notary attestations stored for a root-header
+const MaxAttestations = 16
+
+// dbKeyAttestations is the storage key prefix of notary attestations of root-headers (by hash of the header)
+const dbKeyAttestations = ".attestations/"
+
+var (
+	errInvalidAttestation = errors.New("invalid attestation")
+	errUntrustedNotary    = errors.New("attestation of untrusted notary")
+)
+
+// WithTrustedNotaries sets public keys of the notaries which attestations are stored by VFS.
+// Attestations of other notaries are refused.
+func WithTrustedNotaries(notaries ...crypto.PublicKey) Option {
+	return func(f *fileSystem) {
+		f.notaries = notaries
+	}
+}
+
+// AttestedTime returns the earliest time when the root-header is attested by one of the notaries
+// (zero time if it is not attested). The version of the site existed not later than this time.
+func AttestedTime(aa []*crypto.Attestation, notaries ...crypto.PublicKey) (t time.Time) {
+	for _, a := range aa {
+		for _, pub := range notaries {
+			if a.Notary.Equal(pub) && (t.IsZero() || a.Time.Before(t)) {
+				t = a.Time
+			}
+		}
+	}
+	return
+}
+
+func attestationsKey(hash []byte) string {
+	return dbKeyAttestations + hex.EncodeToString(hash)
+}
+
+// Attestations returns notary attestations of the root-header with the hash
+func (f *fileSystem) Attestations(hash []byte) (aa []*crypto.Attestation, err error) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	defer catch(&err)
+
+	return f.attestations(hash), nil
+}
+
+// AddAttestation verifies and saves the attestation of a trusted notary of the current or one of the previous root-headers
+func (f *fileSystem) AddAttestation(a *crypto.Attestation) (err error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	defer catch(&err)
+
+	if !a.Verify(a.Hash) {
+		return errInvalidAttestation
+	}
+	if !f.isTrustedNotary(a.Notary) {
+		return errUntrustedNotary
+	}
+	if !bytes.Equal(a.Hash, f.root().Hash()) {
+		var h Header
+		try(db.GetJSON(f.db, historyKey(a.Hash), &h))
+		if h == nil {
+			return ErrNotFound
+		}
+	}
+	if aa, ok := mergeAttestation(f.attestations(a.Hash), a); ok {
+		try(f.db.Execute(func(tx db.Transaction) error {
+			return db.PutJSON(tx, attestationsKey(a.Hash), aa)
+		}))
+	}
+	return
+}
+
+func (f *fileSystem) attestations(hash []byte) (aa []*crypto.Attestation) {
+	try(db.GetJSON(f.db, attestationsKey(hash), &aa))
+	return
+}
+
+func (f *fileSystem) isTrustedNotary(pub crypto.PublicKey) bool {
+	for _, n := range f.notaries {
+		if n.Equal(pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// attestedTime returns the earliest time of the root-header attested by the trusted notaries
+// in the stored attestations or in the valid attestations aa
+func (f *fileSystem) attestedTime(hash []byte, aa []*crypto.Attestation) time.Time {
+	trusted := f.attestations(hash)
+	for _, a := range aa {
+		if f.isTrustedNotary(a.Notary) && a.Verify(hash) {
+			trusted = append(trusted, a)
+		}
+	}
+	return AttestedTime(trusted, f.notaries...)
+}
+
+// commitAttestations returns attestations of the root-headers of the commit
+func (f *fileSystem) commitAttestations(hh []Header) (aa []*crypto.Attestation) {
+	for _, h := range hh {
+		aa = append(aa, f.attestations(h.Hash())...)
+	}
+	return
+}
+
+// mergeAttestation adds attestation to the list keeping the earliest attestation of each notary
+func mergeAttestation(aa []*crypto.Attestation, a *crypto.Attestation) ([]*crypto.Attestation, bool) {
+	for i, a1 := range aa {
+		if a1.Notary.Equal(a.Notary) {
+			if !a.Time.Before(a1.Time) {
+				return aa, false
+			}
+			aa[i] = a
+			return aa, true
+		}
+	}
+	if len(aa) >= MaxAttestations {
+		return aa, false
+	}
+	return append(aa, a), true
+}
@@ -0,0 +1,88 @@
+package vfs
+
+import (
+	"github.com/denisskin/dweb/crypto"
+	"testing"
+	"time"
+)
+
+var testNotary = crypto.NewPrivateKeyFromSeed("notary")
+
+func newNotarizedMemVFS() VFS {
+	return newMemVFS(WithTrustedNotaries(testNotary.PublicKey()))
+}
+
+func newTestAttestation(hash []byte, ts time.Time) *crypto.Attestation {
+	return tryVal(crypto.NewAttestation(testNotary, hash, ts))
+}
+
+func TestFileSystem_AddAttestation(t *testing.T) {
+	ts := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	s := applyCommit(newNotarizedMemVFS(), "commit1", "commit2")
+	hh := tryVal(s.History(0))
+
+	// attestation of the previous version
+	err := s.AddAttestation(newTestAttestation(hh[0].Hash(), ts))
+	assert(t, err == nil)
+	aa, err := s.Attestations(hh[0].Hash())
+	assert(t, err == nil)
+	assert(t, len(aa) == 1)
+	assert(t, AttestedTime(aa, testNotary.PublicKey()).Equal(ts))
+	assert(t, AttestedTime(aa, testPub).IsZero())
+
+	// the earliest attestation of the notary is kept
+	try(s.AddAttestation(newTestAttestation(hh[0].Hash(), ts.Add(time.Hour))))
+	assert(t, AttestedTime(tryVal(s.Attestations(hh[0].Hash())), testNotary.PublicKey()).Equal(ts))
+	try(s.AddAttestation(newTestAttestation(hh[0].Hash(), ts.Add(-time.Hour))))
+	aa = tryVal(s.Attestations(hh[0].Hash()))
+	assert(t, len(aa) == 1)
+	assert(t, AttestedTime(aa, testNotary.PublicKey()).Equal(ts.Add(-time.Hour)))
+
+	// attestation of the current version
+	err = s.AddAttestation(newTestAttestation(hh[1].Hash(), ts))
+	assert(t, err == nil)
+
+	// unknown root-header
+	err = s.AddAttestation(newTestAttestation(crypto.Hash([]byte("unknown")), ts))
+	assert(t, err == ErrNotFound)
+
+	// attestation of untrusted notary
+	err = s.AddAttestation(tryVal(crypto.NewAttestation(testPrv.SubKey("notary"), hh[1].Hash(), ts)))
+	assert(t, err == errUntrustedNotary)
+	assert(t, len(tryVal(s.Attestations(hh[1].Hash()))) == 1)
+
+	// invalid attestation
+	a := newTestAttestation(hh[1].Hash(), ts)
+	a.Time = a.Time.Add(time.Second)
+	err = s.AddAttestation(a)
+	assert(t, err != nil)
+}
+
+func TestFileSystem_Commit_attestations(t *testing.T) {
+	ts := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	s := applyCommit(newNotarizedMemVFS(), "commit1", "commit2")
+	hh := tryVal(s.History(0))
+	try(s.AddAttestation(newTestAttestation(hh[0].Hash(), ts)))
+	try(s.AddAttestation(newTestAttestation(hh[1].Hash(), ts)))
+
+	// replica receives attestations with the commit
+	commit := tryVal(s.GetCommit(0))
+	assert(t, len(commit.Attestations) == 2)
+	replica := newNotarizedMemVFS()
+	err := replica.Commit(commit)
+	assert(t, err == nil)
+	assert(t, len(tryVal(replica.Attestations(hh[0].Hash()))) == 1)
+	assert(t, len(tryVal(replica.Attestations(hh[1].Hash()))) == 1)
+
+	// replica without trusted notaries does not store attestations
+	replica = newMemVFS()
+	err = replica.Commit(tryVal(s.GetCommit(0)))
+	assert(t, err == nil)
+	assert(t, len(tryVal(replica.Attestations(hh[1].Hash()))) == 0)
+
+	// commit with invalid attestation
+	commit = tryVal(s.GetCommit(0))
+	commit.Attestations[1].Time = ts.Add(time.Second)
+	err = newNotarizedMemVFS().Commit(commit)
+	assert(t, err != nil)
+}
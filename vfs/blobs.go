@@ -0,0 +1,180 @@
+package vfs
+
+import (
+	"fmt"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db"
+	"io"
+)
+
+// storage key prefixes of file contents and their reference counters (by BlobID of the content)
+const (
+	dbKeyBlobs    = ".blobs/"
+	dbKeyBlobRefs = ".blobrefs/"
+)
+
+// BlobStore is a content-addressed store of file contents.
+//
+// Content is stored once by its BlobID and counted by references of files of the sites sharing the store,
+// so identical files of different paths, versions and sites are stored once. Content is deleted with its last
+// reference. A crash between updates of the site and of the store can only leave excess references
+// (content is kept, never lost).
+//
+// Reference counters are read out of the transaction that updates them, so the storage must serialize
+// transactions (Execute), as the storages of the db package do.
+type BlobStore struct {
+	db db.Storage
+}
+
+// NewBlobStore returns blob store persisted in the storage
+func NewBlobStore(storage db.Storage) *BlobStore {
+	return &BlobStore{storage}
+}
+
+// WithBlobStore sets store of file contents shared by the sites.
+// By default, contents are stored in the storage of the site.
+func WithBlobStore(bs *BlobStore) Option {
+	return func(f *fileSystem) {
+		f.blobs = bs
+	}
+}
+
+// BlobID returns identifier of content of the file h of the tree with the root-header.
+//
+// Merkle root alone doesn't identify content: the file of hashes of parts of another file has the same Merkle root
+// with another part size. So the content is identified by hash algorithm, size, part size and Merkle root.
+func BlobID(root, h Header) string {
+	return blobID(root.HashAlgorithm(), h.FileSize(), partSizeOf(root, h), h.FileMerkle())
+}
+
+func blobID(alg crypto.HashAlgorithm, size, partSize int64, merkle []byte) string {
+	return fmt.Sprintf("%x.%d.%d.%s", merkle, size, partSize, alg)
+}
+
+// Open opens content by its BlobID (db.ErrNotFound if the content is not stored)
+func (s *BlobStore) Open(id string) (io.ReadSeekCloser, error) {
+	key := dbKeyBlobs + id
+	if ok, err := s.db.Exists(key); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, db.ErrNotFound
+	}
+	return s.db.Open(key)
+}
+
+// Refs returns count of references to content by its BlobID
+func (s *BlobStore) Refs(id string) (n int64, err error) {
+	err = db.GetJSON(s.db, dbKeyBlobRefs+id, &n)
+	return
+}
+
+// put writes contents of the commit files that are not stored yet and adds references refs.
+// Contents of the files are read from the commit body in order of the headers.
+func (s *BlobStore) put(alg crypto.HashAlgorithm, hh []Header, body io.Reader, rootPartSize int64, refs map[string]int64) error {
+	return s.db.Execute(func(tx db.Transaction) (err error) {
+		defer catch(&err)
+		written := map[string]bool{}
+		for _, h := range hh {
+			hSize, hMerkle := h.FileSize(), h.FileMerkle()
+			if hSize == 0 && len(hMerkle) == 0 {
+				continue
+			}
+			partSize := h.PartSize()
+			if partSize == 0 {
+				partSize = rootPartSize
+			}
+			require(partSize > 0, "empty commit-header Part-Size")
+
+			key := dbKeyBlobs + blobID(alg, hSize, partSize, hMerkle)
+			if written[key] || tryVal(s.db.Exists(key)) { // skip stored content
+				n, err := io.CopyN(io.Discard, body, hSize)
+				require(err == nil && n == hSize, "invalid commit-body")
+				continue
+			}
+			try(tx.Put(key, alg.NewMerkleReader(body, hSize, partSize, hMerkle)))
+			written[key] = true
+		}
+		s.updateRefs(tx, refs)
+		return
+	})
+}
+
+// release removes references refs
+func (s *BlobStore) release(refs map[string]int64) error {
+	return s.db.Execute(func(tx db.Transaction) (err error) {
+		defer catch(&err)
+		neg := make(map[string]int64, len(refs))
+		for key, n := range refs {
+			neg[key] = -n
+		}
+		s.updateRefs(tx, neg)
+		return
+	})
+}
+
+// updateRefs adds delta to reference counters of contents (by BlobID); unreferenced contents are deleted
+func (s *BlobStore) updateRefs(tx db.Transaction, delta map[string]int64) {
+	for id, d := range delta {
+		if d == 0 {
+			continue
+		}
+		var n int64
+		try(db.GetJSON(s.db, dbKeyBlobRefs+id, &n))
+		if n += d; n > 0 {
+			try(db.PutJSON(tx, dbKeyBlobRefs+id, n))
+		} else {
+			try(tx.Delete(dbKeyBlobRefs + id))
+			try(tx.Delete(dbKeyBlobs + id))
+		}
+	}
+}
+
+// migrateContents moves contents of the files stored in the storage of the site by their paths
+// (storage layout of protocol 0.1) to the blob store.
+// A crash during migration can only leave excess references, the moved contents are kept.
+func (f *fileSystem) migrateContents() {
+	root := f.root()
+	for path, nd := range f.nodes {
+		h := nd.Header
+		if nd.isDir() || len(h.FileMerkle()) == 0 || !tryVal(f.db.Exists(path)) {
+			continue
+		}
+		fl := tryVal(f.db.Open(path))
+		err := f.blobs.put(f.hashAlg(), []Header{h}, fl, root.PartSize(), map[string]int64{BlobID(root, h): 1})
+		fl.Close()
+		try(err)
+		try(f.db.Execute(func(tx db.Transaction) error {
+			return tx.Delete(path)
+		}))
+	}
+}
+
+// blobRefs returns counts of references of the tree files to contents (by BlobID)
+func blobRefs(tree map[string]*fsNode) map[string]int64 {
+	refs := map[string]int64{}
+	root := tree["/"].Header
+	for _, nd := range tree {
+		if !nd.isDir() && len(nd.Header.FileMerkle()) > 0 {
+			refs[BlobID(root, nd.Header)]++
+		}
+	}
+	return refs
+}
+
+// diffBlobRefs returns references added and removed by the new tree
+func diffBlobRefs(oldTree, newTree map[string]*fsNode) (added, removed map[string]int64) {
+	added, removed = blobRefs(newTree), blobRefs(oldTree)
+	for key, n := range removed {
+		if m := added[key]; m > n {
+			added[key] = m - n
+			delete(removed, key)
+		} else if m == n {
+			delete(added, key)
+			delete(removed, key)
+		} else {
+			removed[key] = n - m
+			delete(added, key)
+		}
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package vfs
+
+import (
+	"bytes"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db"
+	"github.com/denisskin/dweb/db/filedb"
+	"github.com/denisskin/dweb/db/memdb"
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func blobCount(storage db.Storage) (n int) {
+	try(storage.List(dbKeyBlobs, func(string) error {
+		n++
+		return nil
+	}))
+	return
+}
+
+func readFile(f VFS, path string) string {
+	r := tryVal(f.OpenAt(path, 0))
+	defer r.Close()
+	return string(tryVal(io.ReadAll(r)))
+}
+
+func commitFiles(f VFS, files map[string]string) {
+	src := fstest.MapFS{}
+	for name, data := range files {
+		src[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	try(f.Commit(tryVal(MakeCommit(f, testPrv, src, time.Now()))))
+}
+
+func TestBlobStore_dedup(t *testing.T) {
+	storage := memdb.New()
+	s := tryVal(OpenVFS(testPub, storage))
+	bs := s.(*fileSystem).blobs
+
+	commitFiles(s, map[string]string{"a.txt": "same", "b/c.txt": "same", "d.txt": "other"})
+	id := BlobID(tryVal(s.FileHeader("/")), tryVal(s.FileHeader("/a.txt")))
+	assert(t, blobCount(storage) == 2)
+	assert(t, tryVal(bs.Refs(id)) == 2)
+	assert(t, readFile(s, "/b/c.txt") == "same")
+
+	// new version keeps unchanged content
+	commitFiles(s, map[string]string{"a.txt": "same", "d.txt": "changed"})
+	assert(t, blobCount(storage) == 2)
+	assert(t, tryVal(bs.Refs(id)) == 1)
+	assert(t, readFile(s, "/a.txt") == "same")
+	assert(t, readFile(s, "/d.txt") == "changed")
+
+	// content is deleted with the last reference
+	commitFiles(s, map[string]string{"d.txt": "changed"})
+	assert(t, blobCount(storage) == 1)
+	assert(t, tryVal(bs.Refs(id)) == 0)
+}
+
+func TestBlobStore_sharedBySites(t *testing.T) {
+	storage := memdb.New()
+	bs := NewBlobStore(db.Sub(storage, "blobs"))
+	s1 := tryVal(OpenVFS(testPub, db.Sub(storage, "site1"), WithBlobStore(bs)))
+	s2 := tryVal(OpenVFS(testPub, db.Sub(storage, "site2"), WithBlobStore(bs)))
+
+	commitFiles(s1, map[string]string{"index.html": "<html>"})
+	commitFiles(s2, map[string]string{"main.html": "<html>"})
+	id := BlobID(tryVal(s1.FileHeader("/")), tryVal(s1.FileHeader("/index.html")))
+	assert(t, tryVal(bs.Refs(id)) == 2)
+	assert(t, blobCount(bs.db) == 1)
+
+	// site2 gets content of site1 by commit
+	s3 := tryVal(OpenVFS(testPub, db.Sub(storage, "site3"), WithBlobStore(bs)))
+	try(s3.Commit(tryVal(s1.GetCommit(0))))
+	assert(t, readFile(s3, "/index.html") == "<html>")
+	assert(t, tryVal(bs.Refs(id)) == 3)
+
+	commitFiles(s1, map[string]string{})
+	commitFiles(s2, map[string]string{})
+	assert(t, tryVal(bs.Refs(id)) == 1)
+	assert(t, readFile(s3, "/index.html") == "<html>")
+}
+
+func TestBlobStore_contentPoisoning(t *testing.T) {
+	storage := memdb.New()
+	bs := NewBlobStore(db.Sub(storage, "blobs"))
+	other := testPrv.SubKey("other")
+	s1 := tryVal(OpenVFS(testPub, db.Sub(storage, "site1"), WithBlobStore(bs)))
+	s2 := tryVal(OpenVFS(other.PublicKey(), db.Sub(storage, "site2"), WithBlobStore(bs)))
+
+	// the file of hashes of parts of the 2-part file has the same Merkle root
+	data := bytes.Repeat([]byte("0123456789abcdef"), DefaultFilePartSize/8)
+	hashes := append(crypto.Hash(data[:DefaultFilePartSize]), crypto.Hash(data[DefaultFilePartSize:])...)
+	src := fstest.MapFS{"a.bin": {Data: hashes}}
+	try(s2.Commit(tryVal(MakeCommit(s2, other, src, time.Now()))))
+
+	commitFiles(s1, map[string]string{"a.bin": string(data)})
+	h1, h2 := tryVal(s1.FileHeader("/a.bin")), tryVal(s2.FileHeader("/a.bin"))
+	assert(t, bytes.Equal(h1.FileMerkle(), h2.FileMerkle()))
+	assert(t, readFile(s1, "/a.bin") == string(data))
+	assert(t, readFile(s2, "/a.bin") == string(hashes))
+	assert(t, blobCount(bs.db) == 2)
+}
+
+func TestBlobStore_rejectedCommit(t *testing.T) {
+	storage := tryVal(filedb.Open(t.TempDir()))
+	s := tryVal(OpenVFS(testPub, storage))
+	commitFiles(s, map[string]string{"a.txt": "aaa"})
+
+	commit := tryVal(MakeCommit(s, testPrv, fstest.MapFS{"a.txt": {Data: []byte("bbb")}}, time.Now()))
+	commit.Body = tryVal(MakeCommit(s, testPrv, fstest.MapFS{"a.txt": {Data: []byte("ccc")}}, time.Now())).Body // wrong content
+
+	err := s.Commit(commit)
+	assert(t, err != nil)
+	assert(t, blobCount(storage) == 1)
+	assert(t, readFile(s, "/a.txt") == "aaa")
+}
+
+func TestBlobStore_missingContent(t *testing.T) {
+	storage := memdb.New()
+	s := tryVal(OpenVFS(testPub, storage))
+	commitFiles(s, map[string]string{"a.txt": "aaa"})
+
+	id := BlobID(tryVal(s.FileHeader("/")), tryVal(s.FileHeader("/a.txt")))
+	try(storage.Execute(func(tx db.Transaction) error {
+		return tx.Delete(dbKeyBlobs + id)
+	}))
+	_, err := s.OpenAt("/a.txt", 0)
+	assert(t, err == db.ErrNotFound)
+}
+
+func TestBlobStore_migrateContents(t *testing.T) {
+	storage := memdb.New()
+	s := tryVal(OpenVFS(testPub, storage))
+	commitFiles(s, map[string]string{"a.txt": "same", "b/c.txt": "same"})
+	id := BlobID(tryVal(s.FileHeader("/")), tryVal(s.FileHeader("/a.txt")))
+
+	// contents are stored by paths of the files (protocol 0.1)
+	try(storage.Execute(func(tx db.Transaction) error {
+		try(tx.Put("/a.txt", bytes.NewBufferString("same")))
+		try(tx.Put("/b/c.txt", bytes.NewBufferString("same")))
+		try(tx.DeletePrefix(dbKeyBlobs))
+		return tx.DeletePrefix(dbKeyBlobRefs)
+	}))
+
+	s = tryVal(OpenVFS(testPub, storage))
+	assert(t, readFile(s, "/a.txt") == "same")
+	assert(t, readFile(s, "/b/c.txt") == "same")
+	assert(t, blobCount(storage) == 1)
+	assert(t, tryVal(s.(*fileSystem).blobs.Refs(id)) == 2)
+	assert(t, !tryVal(storage.Exists("/a.txt")) && !tryVal(storage.Exists("/b/c.txt")))
+}
@@ -11,8 +11,13 @@ import (
 )
 
 type Commit struct {
-	Headers []Header
-	Body    io.ReadCloser
+	Headers  []Header
+	Body     io.ReadCloser
+	KeyChain []Header         // records of the site key chain newer than the base version of the commit
+	History  []Header         // root-headers of the versions between the base version and the commit version
+	Bases    []*DelegatedBase // trees of the versions preceding the delegated versions (see DelegatedBase)
+
+	Attestations []*crypto.Attestation // notary attestations of the root-headers of the commit and its history
 }
 
 func (c *Commit) Root() Header {
@@ -42,12 +47,101 @@ func (c *Commit) Trace() {
 	traceHeaders(c.Headers)
 }
 
-func MakeCommit(vfs VFS, prv crypto.PrivateKey, src fs.FS, ts time.Time) (commit *Commit, err error) {
+// MakeCommit makes commit of files of src signed by the signer.
+// crypto.PrivateKey is the signer with in-memory key.
+func MakeCommit(vfs VFS, signer crypto.Signer, src fs.FS, ts time.Time) (*Commit, error) {
+	return makeCommit(vfs, "/", src, ts, func(root *Header) {
+		root.SetDelegation(nil)
+		try(root.SignBy(signer))
+	})
+}
+
+// MakeUnsignedCommit makes commit with unsigned root-header to sign it out of the process (air-gapped signing).
+//
+// The root-header (or its SigningDigest) is exported to the signing machine,
+// and the signature made there is imported by Commit.SetSignature.
+func MakeUnsignedCommit(vfs VFS, pub crypto.PublicKey, src fs.FS, ts time.Time) (*Commit, error) {
+	return makeCommit(vfs, "/", src, ts, func(root *Header) {
+		root.SetDelegation(nil)
+		root.SetPublicKey(pub)
+		root.Delete(headerSignature)
+	})
+}
+
+// SetSignature sets the signature of the root-header of the commit made by MakeUnsignedCommit
+func (c *Commit) SetSignature(sig []byte) error {
+	return c.Headers[0].SetSignature(sig)
+}
+
+// MakeDelegatedCommit makes commit signed by delegated key.
+// Only files under the path prefix of the last certificate of the chain are committed.
+func MakeDelegatedCommit(vfs VFS, signer crypto.Signer, chain []Header, src fs.FS, ts time.Time) (*Commit, error) {
+	if len(chain) == 0 {
+		return nil, errInvalidDelegation
+	}
+	return makeCommit(vfs, chain[len(chain)-1].Path(), src, ts, func(root *Header) {
+		root.SetDelegation(chain)
+		try(root.SignBy(signer))
+	})
+}
+
+// MakeMultiSigCommit makes commit of the site owned by M-of-N public key pub.
+// The root-header of the commit is signed by the signer only.
+// Other signers add their signatures by Header.AddSignature to the copies of the root-header,
+// then the signatures are joined by Header.MergeSignatures.
+func MakeMultiSigCommit(vfs VFS, pub crypto.PublicKey, signer crypto.Signer, src fs.FS, ts time.Time) (*Commit, error) {
+	if !pub.IsMulti() {
+		return nil, crypto.ErrInvalidMultiKey
+	}
+	return makeCommit(vfs, "/", src, ts, func(root *Header) {
+		root.SetDelegation(nil)
+		root.SetPublicKey(pub)
+		root.Delete(headerSignature)
+		try(root.AddSignature(signer))
+	})
+}
+
+// treeReader reads headers of the file tree
+type treeReader interface {
+	FileHeader(path string) (Header, error)
+	ReadDir(path string) ([]Header, error)
+}
+
+// commitSource is the source of a new commit made on the vfs (see makeCommit)
+type commitSource struct {
+	tree  treeReader                        // tree which the commit is made on
+	files fs.FS                             // files to commit
+	nonce func(path string) ([]byte, error) // returns nonce of the encrypted file (private sites only)
+	seal  func(root Header) (Header, error) // seals the new root-header (private sites only)
+}
+
+// commitSourcer is implemented by VFS that defines how commits are made on it.
+//
+// The file system reads its tree regardless of revocation of its current version (see verifyNotRevoked),
+// so the next key can make the recovery commit on the replica that honors revocations.
+// Views of private sites encrypt files of commits (see OpenPrivateVFS).
+type commitSourcer interface {
+	commitSource(src fs.FS) (*commitSource, error)
+}
+
+func newCommitSource(vfs VFS, src fs.FS) (*commitSource, error) {
+	if v, ok := vfs.(commitSourcer); ok {
+		return v.commitSource(src)
+	}
+	return &commitSource{tree: vfs, files: src}, nil
+}
+
+func makeCommit(vfs VFS, prefix string, src fs.FS, ts time.Time, sign func(root *Header)) (commit *Commit, err error) {
 	defer catch(&err)
 
-	root := tryVal(vfs.FileHeader("/"))
+	cs := tryVal(newCommitSource(vfs, src))
+	base, src := cs.tree, cs.files
+	isPrivate := cs.seal != nil
+	root := tryVal(base.FileHeader("/"))
+	require(isPrivate || !root.IsPrivate(), "commit of the private site must be made by its reader")
 	ver := root.Ver() + 1       // new ver
 	partSize := root.PartSize() //
+	alg := root.HashAlgorithm()
 
 	files := newFilesReader()
 	commit = &Commit{Body: files}
@@ -63,7 +157,11 @@ func MakeCommit(vfs VFS, prv crypto.PrivateKey, src fs.FS, ts time.Time) (commit
 		}
 		var dfsPath = path[1:] // trim prefix '/'
 		var isDir = strings.HasSuffix(path, "/")
-		h, err := vfs.FileHeader(path)
+		var inPrefix = strings.HasPrefix(path, prefix)
+		if !inPrefix && !(isDir && strings.HasPrefix(prefix, path)) { // out of the prefix and its parent dirs
+			return
+		}
+		h, err := base.FileHeader(path)
 		if err == ErrNotFound {
 			err = nil
 		}
@@ -76,13 +174,16 @@ func MakeCommit(vfs VFS, prv crypto.PrivateKey, src fs.FS, ts time.Time) (commit
 		var fileMerkle []byte
 		var fileSize int64
 		if !isDir {
-			fileSize, fileMerkle, _ = fsMerkleRoot(src, dfsPath, partSize)
+			fileSize, fileMerkle, _ = fsMerkleRoot(alg, src, dfsPath, partSize)
 		}
-		if path == "/" || !exists || !isDir && !bytes.Equal(h.GetBytes(headerFileMerkle), fileMerkle) { // not exists or changed
+		if path == "/" || !exists || inPrefix && !isDir && !bytes.Equal(h.GetBytes(headerFileMerkle), fileMerkle) { // not exists (incl. parent dirs of the prefix) or changed
 			h.SetInt(headerVer, ver) // set new version
 			if !isDir {
 				h.SetInt(headerFileSize, fileSize)
 				h.SetBytes(headerFileMerkle, fileMerkle)
+				if isPrivate {
+					h.SetBytes(headerNonce, tryVal(cs.nonce(dfsPath)))
+				}
 				files.add(func() (io.ReadCloser, error) {
 					return src.Open(dfsPath)
 				})
@@ -120,7 +221,7 @@ func MakeCommit(vfs VFS, prv crypto.PrivateKey, src fs.FS, ts time.Time) (commit
 	var vfsWalk func(Header)
 	vfsWalk = func(h Header) {
 		path := h.Path()
-		if !onDisk[path] { // delete node
+		if !onDisk[path] && strings.HasPrefix(path, prefix) { // delete node
 			h = Header{{headerPath, []byte(path)}}
 			h.SetInt(headerVer, ver)
 			h.SetInt(headerDeleted, 1)
@@ -131,7 +232,7 @@ func MakeCommit(vfs VFS, prv crypto.PrivateKey, src fs.FS, ts time.Time) (commit
 		if !inBatch[path] {
 			hh = append(hh, h)
 		}
-		ff, err := vfs.ReadDir(path)
+		ff, err := base.ReadDir(path)
 		if err == ErrNotFound {
 			err = nil
 		}
@@ -154,16 +255,24 @@ func MakeCommit(vfs VFS, prv crypto.PrivateKey, src fs.FS, ts time.Time) (commit
 		newRoot.SetTime(headerCreated, ts)
 	}
 	newRoot.SetTime(headerUpdated, ts)
+	if root.Ver() > 0 {
+		newRoot.SetBytes(headerPrev, root.Hash())
+	} else {
+		newRoot.Delete(headerPrev)
+	}
 	newRoot.SetInt(headerTreeVolume, ndRoot.totalVolume())
 	newRoot.SetBytes(headerTreeMerkle, ndRoot.childrenMerkleRoot())
-	newRoot.Sign(prv)
+	if isPrivate {
+		*newRoot = tryVal(cs.seal(*newRoot))
+	}
+	sign(newRoot)
 	return
 }
 
-func fsMerkleRoot(dfs fs.FS, path string, partSize int64) (size int64, merkle []byte, hashes [][]byte) {
+func fsMerkleRoot(alg crypto.HashAlgorithm, dfs fs.FS, path string, partSize int64) (size int64, merkle []byte, hashes [][]byte) {
 	f := tryVal(dfs.Open(path))
 	defer f.Close()
-	w := crypto.NewMerkleHash(partSize)
+	w := alg.NewParallelMerkleHash(partSize, 0)
 	tryVal(io.Copy(w, f))
 	return w.Written(), w.Root(), w.Leaves()
 }
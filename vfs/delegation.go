@@ -0,0 +1,118 @@
+package vfs
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"strings"
+	"time"
+)
+
+// MaxDelegationChainLength is the max count of certificates in the delegation chain
+const MaxDelegationChainLength = 4
+
+// delegation certificate fields
+const (
+	headerDelegate = "Delegate" // public key of the delegate
+	headerSite     = "Site"     // public key of the site
+	headerExpires  = "Expires"  //
+)
+
+var errInvalidDelegation = errors.New("invalid commit-header Delegation")
+
+// NewDelegation issues a certificate that authorizes the key pub
+// to sign commits of the site for files with the path prefix until the expiry time.
+//
+// The certificate is a header signed by the issuer: {Delegate, Site, Path, Expires, Public-Key, Signature}.
+func NewDelegation(issuer crypto.Signer, site, pub crypto.PublicKey, prefix string, expires time.Time) (h Header, err error) {
+	if !IsValidPath(prefix) || !strings.HasSuffix(prefix, "/") {
+		return nil, errInvalidPath
+	}
+	h.Add(headerDelegate, pub.Encode())
+	h.Add(headerSite, site.Encode())
+	h.Add(headerPath, prefix)
+	h.AddTime(headerExpires, expires)
+	if err = h.SignBy(issuer); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Delegation returns the chain of delegation certificates of the root-header
+func (h Header) Delegation() (chain []Header, err error) {
+	if v := h.GetBytes(headerDelegation); len(v) > 0 {
+		err = json.Unmarshal(v, &chain)
+	}
+	return
+}
+
+func (h *Header) SetDelegation(chain []Header) {
+	if len(chain) == 0 {
+		h.Delete(headerDelegation)
+		return
+	}
+	h.SetBytes(headerDelegation, tryVal(json.Marshal(chain)))
+}
+
+// verifyDelegation verifies the delegation chain of the root-header of the site signed not by the owner.
+// It returns the path prefix which the signer of the header is authorized for.
+//
+// Certificates must not expire before the header was updated and before the time t
+// (local or notary-attested time when the header was received; zero t is not checked).
+func verifyDelegation(site, owner crypto.PublicKey, b Header, t time.Time) (prefix string, err error) {
+	chain, err := b.Delegation()
+	if err != nil || len(chain) == 0 || len(chain) > MaxDelegationChainLength {
+		return "", errInvalidDelegation
+	}
+	if len(VerifyHeaders(chain...)) > 0 {
+		return "", errInvalidDelegation
+	}
+	issuer, prefix := owner, "/"
+	for _, c := range chain {
+		path, expires := c.Path(), c.GetTime(headerExpires)
+		if !c.PublicKey().Equal(issuer) ||
+			c.Get(headerSite) != site.Encode() ||
+			!IsValidPath(path) ||
+			!strings.HasSuffix(path, "/") ||
+			!strings.HasPrefix(path, prefix) ||
+			expires.IsZero() ||
+			b.Updated().After(expires) ||
+			t.After(expires) {
+			return "", errInvalidDelegation
+		}
+		issuer, prefix = crypto.DecodePublicKey(c.Get(headerDelegate)), path
+	}
+	if !b.PublicKey().Equal(issuer) {
+		return "", errInvalidDelegation
+	}
+	return
+}
+
+// authorizedPrefix returns the path prefix which the signer of the root-header of the site is authorized for:
+// "/" for the owner and the next key, the delegated prefix for the delegate of the owner (see verifyDelegation).
+func authorizedPrefix(site, owner crypto.PublicKey, last, h Header, t time.Time) (string, error) {
+	if h.PublicKey().Equal(owner) || last.isNextKey(h.PublicKey()) {
+		if h.Has(headerDelegation) {
+			return "", errInvalidDelegation
+		}
+		return "/", nil
+	}
+	return verifyDelegation(site, owner, h, t)
+}
+
+// rootFieldsEqual says that root-headers have the same fields except of the fields updated by each commit
+func rootFieldsEqual(a, b Header) bool {
+	a, b = a.withoutCommitFields(), b.withoutCommitFields()
+	return len(a) == len(b) && toJSON(a) == toJSON(b)
+}
+
+func (h Header) withoutCommitFields() (res Header) {
+	for _, kv := range h {
+		switch kv.Name {
+		case headerVer, headerUpdated, headerTreeVolume, headerTreeMerkle, headerPublicKey, headerSignature, headerDelegation, headerPrev:
+		default:
+			res = append(res, kv)
+		}
+	}
+	return
+}
@@ -0,0 +1,227 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// dbKeyDelegatedBases is the storage key of the base trees of the delegated versions of the site
+const dbKeyDelegatedBases = ".bases"
+
+var errInvalidDelegatedBase = errors.New("invalid commit Bases")
+
+// DelegatedBase describes the file tree of the version Ver by its difference from the tree of a newer version:
+// subtrees of the path prefixes delegated after the version Ver are replaced by their Merkle roots
+// in the tree of the version Ver (nil if the subtree is missing; then the parent dirs of the prefix
+// created after the version Ver are missing too).
+//
+// A commit signed by a delegate carries the bases of the versions preceding its delegated versions,
+// so the files out of the delegated prefix (committed by the owner or by other delegates)
+// are verified against the root-headers of these versions.
+type DelegatedBase struct {
+	Ver      int64
+	Subtrees map[string][]byte
+}
+
+// rebase returns the base relative to the new tree that differs from the current tree within the prefixes
+func (b *DelegatedBase) rebase(tree map[string]*fsNode, prefixes []string) (_ *DelegatedBase, err error) {
+	res := &DelegatedBase{Ver: b.Ver, Subtrees: map[string][]byte{}}
+	var pruned map[string]*fsNode
+	for _, prefix := range outerPrefixes(append(subtreePrefixes(b.Subtrees), prefixes...)) {
+		if merkle, ok := b.Subtrees[prefix]; ok {
+			res.Subtrees[prefix] = merkle
+			continue
+		}
+		if pruned == nil {
+			if pruned, err = prunedTree(tree, b); err != nil {
+				return
+			}
+		}
+		if prefix == "/" {
+			res.Subtrees[prefix] = pruned["/"].childrenMerkleRoot()
+		} else if nd := pruned[prefix]; nd != nil {
+			res.Subtrees[prefix] = nd.merkleRoot()
+		} else {
+			res.Subtrees[prefix] = nil
+		}
+	}
+	return res, nil
+}
+
+func subtreePrefixes(subtrees map[string][]byte) (prefixes []string) {
+	for prefix := range subtrees {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return
+}
+
+// outerPrefixes returns sorted path prefixes which are not under the other ones
+func outerPrefixes(prefixes []string) (res []string) {
+	prefixes = append([]string(nil), prefixes...)
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if len(res) == 0 || !strings.HasPrefix(prefix, res[len(res)-1]) {
+			res = append(res, prefix)
+		}
+	}
+	return
+}
+
+// prunedTree returns the tree of the base version where the subtrees are replaced by the nodes
+// with the given Merkle roots (or removed if the Merkle root is nil)
+func prunedTree(tree map[string]*fsNode, b *DelegatedBase) (map[string]*fsNode, error) {
+	subtrees := b.Subtrees
+	kept := map[string]Header{}
+	for path, nd := range tree {
+		if !isUnderPrefixes(path, subtrees) {
+			kept[path] = nd.Header
+		}
+	}
+	for prefix, merkle := range subtrees {
+		if len(merkle) > 0 {
+			kept[prefix] = Header{{headerPath, []byte(prefix)}}
+			continue
+		}
+		// empty parent dirs of the missing subtree created after the base version (see makeCommit)
+		for dir := dirname(prefix); dir != "/" && kept[dir] != nil && kept[dir].Ver() > b.Ver && !hasChildren(kept, dir); dir = dirname(dir) {
+			delete(kept, dir)
+		}
+	}
+	hh := make([]Header, 0, len(kept))
+	for _, h := range kept {
+		hh = append(hh, h)
+	}
+	sortHeaders(hh)
+	pruned, err := indexTree(hh)
+	if err != nil {
+		return nil, err
+	}
+	for prefix, merkle := range subtrees {
+		if nd := pruned[prefix]; nd != nil {
+			nd.merkle = merkle
+		}
+	}
+	return pruned, nil
+}
+
+func hasChildren(hh map[string]Header, dir string) bool {
+	for path := range hh {
+		if path != dir && strings.HasPrefix(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// prunedMerkleRoot returns Merkle-Root of the tree of the base version (see prunedTree)
+func prunedMerkleRoot(tree map[string]*fsNode, b *DelegatedBase) ([]byte, error) {
+	if merkle, ok := b.Subtrees["/"]; ok {
+		return merkle, nil
+	}
+	pruned, err := prunedTree(tree, b)
+	if err != nil {
+		return nil, err
+	}
+	return pruned["/"].childrenMerkleRoot(), nil
+}
+
+func isUnderPrefixes(path string, subtrees map[string][]byte) bool {
+	for prefix := range subtrees {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDelegatedBases verifies that the bases reconstruct the trees of the root-headers preceding
+// the delegated versions of the tail (signed by delegates of the prefixes) from the tree of the last version.
+// The tail follows the version with the root-header base.
+func verifyDelegatedBases(tree map[string]*fsNode, bases []*DelegatedBase, base Header, tail []Header, prefixes []string) error {
+	if len(bases) != len(tail) {
+		return errInvalidDelegatedBase
+	}
+	for i, b := range bases {
+		root := base
+		if i > 0 {
+			root = tail[i-1]
+		}
+		if b == nil || b.Ver != root.Ver() ||
+			strings.Join(subtreePrefixes(b.Subtrees), "\x00") != strings.Join(outerPrefixes(prefixes[i:]), "\x00") {
+			return errInvalidDelegatedBase
+		}
+		if merkle, err := prunedMerkleRoot(tree, b); err != nil || !bytes.Equal(merkle, root.TreeMerkleRoot()) {
+			return errInvalidDelegatedBase
+		}
+		if i == 0 {
+			continue
+		}
+		// trees of the neighbouring versions differ only within the prefix of the delegate
+		for prefix, merkle := range b.Subtrees {
+			if !strings.HasPrefix(prefix, prefixes[i-1]) && !strings.HasPrefix(prefixes[i-1], prefix) {
+				if m, ok := bases[i-1].Subtrees[prefix]; !ok || !bytes.Equal(m, merkle) {
+					return errInvalidDelegatedBase
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// delegatedBases returns the bases of the delegated versions following the last version signed by the owner
+// relative to the new tree of the commit with the lineage of root-headers (see verifyLineage).
+// It reports whether the files of the tree are verified by the bases.
+//
+// Bases of the versions newer than the local one are taken from the commit. If the commit root-header
+// is signed by the owner or the bases of some versions are unknown, it returns nil.
+func (f *fileSystem) delegatedBases(commit *Commit, tree map[string]*fsNode, lineage []Header, prefixes []string) (bases []*DelegatedBase, verified bool, err error) {
+	defer catch(&err)
+	n := len(lineage)
+	if !lineage[n-1].Has(headerDelegation) {
+		return nil, false, nil
+	}
+	s := n - 1 // the first delegated version of the tail
+	for s > 0 && lineage[s-1].Has(headerDelegation) {
+		s--
+	}
+	tail, tailPrefixes := lineage[s:], prefixes[s:]
+	commitBase := func(ver int64) *DelegatedBase {
+		for _, b := range commit.Bases {
+			if b != nil && b.Ver == ver {
+				return b
+			}
+		}
+		return nil
+	}
+	r, base := f.root(), Header(nil)
+	if s > 0 { // the tail follows the version signed by the owner
+		base = lineage[s-1]
+		bases = append(bases, commitBase(base.Ver()))
+	} else { // the tail follows the local version
+		base = r
+		bases = append(bases, tryVal((&DelegatedBase{Ver: r.Ver()}).rebase(f.nodes, tailPrefixes)))
+	}
+	for _, h := range tail[:len(tail)-1] {
+		bases = append(bases, commitBase(h.Ver()))
+	}
+	for _, b := range bases {
+		if b == nil { // unknown
+			return nil, false, nil
+		}
+	}
+	try(verifyDelegatedBases(tree, bases, base, tail, tailPrefixes))
+	if s == 0 && r.Has(headerDelegation) { // the local version is delegated too
+		if f.bases == nil { // unknown
+			return nil, true, nil
+		}
+		local := make([]*DelegatedBase, len(f.bases))
+		for i, b := range f.bases {
+			local[i] = tryVal(b.rebase(f.nodes, tailPrefixes))
+		}
+		bases = append(local, bases...)
+	}
+	return bases, true, nil
+}
@@ -0,0 +1,255 @@
+package vfs
+
+import (
+	"github.com/denisskin/dweb/crypto"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestDelegatedVFS() (VFS, time.Time) {
+	s := newMemVFS()
+	ts := time.Now().Truncate(time.Second) // certificates are checked against the local time
+	src := fstest.MapFS{
+		"index.html":     {Data: []byte("index")},
+		"blog/post1.txt": {Data: []byte("post1")},
+	}
+	try(s.Commit(tryVal(MakeCommit(s, testPrv, src, ts))))
+	return s, ts
+}
+
+func TestMakeDelegatedCommit(t *testing.T) {
+	s, ts := newTestDelegatedVFS()
+	s2, _ := newTestDelegatedVFS()
+	editor := testPrv.SubKey("editor")
+	cert, err := NewDelegation(testPrv, testPub, editor.PublicKey(), "/blog/", ts.Add(time.Hour))
+	assert(t, err == nil)
+
+	// delegate changes files in /blog/; files out of the prefix on its disk are ignored
+	src := fstest.MapFS{
+		"blog/post1.txt": {Data: []byte("post1 - edited")},
+		"blog/post2.txt": {Data: []byte("post2")},
+		"other.txt":      {Data: []byte("other")},
+	}
+	commit, err := MakeDelegatedCommit(s, editor, []Header{cert}, src, ts.Add(time.Second))
+	assert(t, err == nil)
+	assert(t, len(commit.Headers) == 3) // "/", "/blog/post1.txt", "/blog/post2.txt"
+
+	err = s.Commit(commit)
+	assert(t, err == nil)
+
+	_, err = s.FileHeader("/index.html")
+	assert(t, err == nil)
+	_, err = s.FileHeader("/other.txt")
+	assert(t, err == ErrNotFound)
+	h, err := s.FileHeader("/blog/post2.txt")
+	assert(t, err == nil)
+	assert(t, h.Ver() == 2)
+
+	// replicate
+	commit, err = s.GetCommit(1)
+	assert(t, err == nil)
+	err = s2.Commit(commit)
+	assert(t, err == nil)
+	assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(s2)))
+
+	// full commit contains files out of the prefix verified by the tree of the owner version
+	commit, err = s.GetCommit(0)
+	assert(t, err == nil)
+	assert(t, len(commit.Bases) == 1)
+	replica := newMemVFS()
+	err = replica.Commit(commit)
+	assert(t, err == nil)
+	assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(replica)))
+	assert(t, readFile(replica, "/index.html") == "index")
+
+	// range proof of file signed by delegate
+	proof, err := s.FileRangeProof("/blog/post2.txt", 0, 5)
+	assert(t, err == nil)
+	assert(t, proof.Verify(testPub) == nil)
+
+	// owner commit removes delegation
+	commit = makeTestCommit(s, "commit1")
+	assert(t, !commit.Root().Has(headerDelegation))
+	err = s.Commit(commit)
+	assert(t, err == nil)
+}
+
+func TestMakeDelegatedCommit_missingParentDir(t *testing.T) {
+	s, ts := newTestDelegatedVFS()
+	s2, _ := newTestDelegatedVFS()
+	editor := testPrv.SubKey("editor")
+	cert := tryVal(NewDelegation(testPrv, testPub, editor.PublicKey(), "/docs/api/", ts.Add(time.Hour)))
+
+	// delegate creates the parent dir of the prefix
+	src := fstest.MapFS{"docs/api/index.txt": {Data: []byte("api")}}
+	commit, err := MakeDelegatedCommit(s, editor, []Header{cert}, src, ts.Add(time.Second))
+	assert(t, err == nil)
+	assert(t, len(commit.Headers) == 4) // "/", "/docs/", "/docs/api/", "/docs/api/index.txt"
+	err = s.Commit(commit)
+	assert(t, err == nil)
+	assert(t, readFile(s, "/docs/api/index.txt") == "api")
+
+	// replicate
+	err = s2.Commit(tryVal(s.GetCommit(1)))
+	assert(t, err == nil)
+	replica := newMemVFS()
+	err = replica.Commit(tryVal(s.GetCommit(0)))
+	assert(t, err == nil)
+	assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(replica)))
+}
+
+func TestMakeDelegatedCommit_chain(t *testing.T) {
+	s, ts := newTestDelegatedVFS()
+	editor, author := testPrv.SubKey("editor"), testPrv.SubKey("author")
+	cert1, _ := NewDelegation(testPrv, testPub, editor.PublicKey(), "/blog/", ts.Add(time.Hour))
+	cert2, _ := NewDelegation(editor, testPub, author.PublicKey(), "/blog/author/", ts.Add(time.Hour))
+
+	src := fstest.MapFS{"blog/author/a.txt": {Data: []byte("a")}}
+	commit, err := MakeDelegatedCommit(s, author, []Header{cert1, cert2}, src, ts.Add(time.Second))
+	assert(t, err == nil)
+	err = s.Commit(commit)
+	assert(t, err == nil)
+
+	_, err = s.FileHeader("/blog/post1.txt")
+	assert(t, err == nil)
+	_, err = s.FileHeader("/blog/author/a.txt")
+	assert(t, err == nil)
+
+	// sub-delegation can not extend the prefix
+	cert2, _ = NewDelegation(editor, testPub, author.PublicKey(), "/", ts.Add(time.Hour))
+	commit, err = MakeDelegatedCommit(s, author, []Header{cert1, cert2}, src, ts.Add(2*time.Second))
+	assert(t, err == nil)
+	err = s.Commit(commit)
+	assert(t, err != nil)
+}
+
+func TestFileSystem_Commit_invalidDelegation(t *testing.T) {
+	s, ts := newTestDelegatedVFS()
+	editor := testPrv.SubKey("editor")
+	src := fstest.MapFS{"blog/post2.txt": {Data: []byte("post2")}}
+
+	makeCommit := func(issuer, signer crypto.PrivateKey, prefix string, expires time.Time) *Commit {
+		cert := tryVal(NewDelegation(issuer, testPub, editor.PublicKey(), prefix, expires))
+		return tryVal(MakeDelegatedCommit(s, signer, []Header{cert}, src, ts.Add(time.Second)))
+	}
+
+	// expired certificate
+	err := s.Commit(makeCommit(testPrv, editor, "/blog/", ts))
+	assert(t, err != nil)
+
+	// certificate of another site
+	cert := tryVal(NewDelegation(testPrv, testPrv.SubKey("site").PublicKey(), editor.PublicKey(), "/blog/", ts.Add(time.Hour)))
+	err = s.Commit(tryVal(MakeDelegatedCommit(s, editor, []Header{cert}, src, ts.Add(time.Second))))
+	assert(t, err != nil)
+
+	// certificate is not issued by the owner
+	err = s.Commit(makeCommit(editor, editor, "/blog/", ts.Add(time.Hour)))
+	assert(t, err != nil)
+
+	// commit is not signed by the delegate
+	err = s.Commit(makeCommit(testPrv, testPrv.SubKey("other"), "/blog/", ts.Add(time.Hour)))
+	assert(t, err != nil)
+
+	// header out of the prefix
+	commit := makeCommit(testPrv, editor, "/blog/", ts.Add(time.Hour))
+	commit.Headers = append(commit.Headers, Header{{headerPath, []byte("/index.html")}, {headerVer, []byte("2")}, {headerDeleted, []byte("1")}})
+	commit.Headers[0].SetBytes(headerTreeMerkle, nil)
+	commit.Headers[0].Sign(editor)
+	err = s.Commit(commit)
+	assert(t, err != nil)
+
+	// delegate can not change root-header fields
+	commit = makeCommit(testPrv, editor, "/blog/", ts.Add(time.Hour))
+	commit.Headers[0].SetInt(headerPartSize, 2048)
+	commit.Headers[0].Sign(editor)
+	err = s.Commit(commit)
+	assert(t, err != nil)
+
+	// valid
+	err = s.Commit(makeCommit(testPrv, editor, "/blog/", ts.Add(time.Hour)))
+	assert(t, err == nil)
+}
+
+func TestFileSystem_Commit_delegatedBases(t *testing.T) {
+	s, ts := newTestDelegatedVFS()
+	editor, writer := testPrv.SubKey("editor"), testPrv.SubKey("writer")
+	cert1 := tryVal(NewDelegation(testPrv, testPub, editor.PublicKey(), "/blog/", ts.Add(time.Hour)))
+	cert2 := tryVal(NewDelegation(testPrv, testPub, writer.PublicKey(), "/docs/", ts.Add(time.Hour)))
+	lagging := newMemVFS()
+	try(lagging.Commit(tryVal(s.GetCommit(0))))
+
+	// versions 2 and 3 are committed by different delegates
+	src := fstest.MapFS{
+		"blog/post1.txt": {Data: []byte("post1 - edited")},
+		"docs/a.txt":     {Data: []byte("a")},
+	}
+	try(s.Commit(tryVal(MakeDelegatedCommit(s, editor, []Header{cert1}, src, ts.Add(time.Second)))))
+	try(s.Commit(tryVal(MakeDelegatedCommit(s, writer, []Header{cert2}, src, ts.Add(2*time.Second)))))
+
+	// full commit
+	commit := tryVal(s.GetCommit(0))
+	assert(t, len(commit.Bases) == 2)
+	replica := newMemVFS()
+	err := replica.Commit(commit)
+	assert(t, err == nil)
+	assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(replica)))
+
+	// commit of the lagging replica contains the changes of both delegates
+	err = lagging.Commit(tryVal(s.GetCommit(1)))
+	assert(t, err == nil)
+	assert(t, readFile(lagging, "/blog/post1.txt") == "post1 - edited")
+
+	// replicas keep the bases for the next replicas
+	err = newMemVFS().Commit(tryVal(replica.GetCommit(0)))
+	assert(t, err == nil)
+	err = newMemVFS().Commit(tryVal(lagging.GetCommit(0)))
+	assert(t, err == nil)
+
+	// commit without bases
+	commit = tryVal(s.GetCommit(0))
+	commit.Bases = nil
+	err = newMemVFS().Commit(commit)
+	assert(t, err != nil)
+
+	// delegate adds the file out of its prefix to the full commit
+	commit = tryVal(s.GetCommit(0))
+	commit.Headers = append(commit.Headers, Header{{headerPath, []byte("/evil.txt")}, {headerVer, []byte("3")}})
+	sortHeaders(commit.Headers)
+	tree := tryVal(indexTree(commit.Headers))
+	commit.Headers[0].SetInt(headerTreeVolume, tree["/"].totalVolume())
+	commit.Headers[0].SetBytes(headerTreeMerkle, tree["/"].childrenMerkleRoot())
+	commit.Headers[0].Sign(writer)
+	err = newMemVFS().Commit(commit)
+	assert(t, err != nil)
+
+	// owner commit resets bases
+	try(s.Commit(makeTestCommit(s, "commit1")))
+	assert(t, len(tryVal(s.GetCommit(0)).Bases) == 0)
+}
+
+func TestFileSystem_Commit_delegationExpiry(t *testing.T) {
+	s := newNotarizedMemVFS()
+	ts := fsHeaders(s)[0].Updated().Add(time.Second) // long ago
+	try(s.Commit(tryVal(MakeCommit(s, testPrv, fstest.MapFS{"index.html": {Data: []byte("index")}}, ts))))
+	editor := testPrv.SubKey("editor")
+	cert := tryVal(NewDelegation(testPrv, testPub, editor.PublicKey(), "/blog/", ts.Add(time.Hour)))
+	src := fstest.MapFS{"blog/post1.txt": {Data: []byte("post1")}}
+
+	// certificate is expired by the local time
+	commit := tryVal(MakeDelegatedCommit(s, editor, []Header{cert}, src, ts.Add(time.Second)))
+	err := s.Commit(commit)
+	assert(t, err != nil)
+
+	// the commit is attested by the trusted notary before the expiry
+	commit.Attestations = []*crypto.Attestation{newTestAttestation(commit.Hash(), ts.Add(2*time.Second))}
+	err = s.Commit(commit)
+	assert(t, err == nil)
+
+	// the delegated version followed by the owner version is accepted by replicas without notaries
+	try(s.Commit(tryVal(MakeCommit(s, testPrv, fstest.MapFS{"index.html": {Data: []byte("index")}}, ts.Add(time.Minute)))))
+	replica := newMemVFS()
+	err = replica.Commit(tryVal(s.GetCommit(0)))
+	assert(t, err == nil)
+	assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(replica)))
+}
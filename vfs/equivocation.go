@@ -0,0 +1,146 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db"
+	"time"
+)
+
+// dbKeyEquivocations is the storage key of equivocation proofs of the site
+const dbKeyEquivocations = ".equivocations"
+
+// maxEquivocations is the max count of stored equivocation proofs (one proof per forked version)
+const maxEquivocations = 64
+
+var errInvalidEquivocation = errors.New("invalid equivocation proof")
+
+// Equivocation is a portable proof that the site forked:
+// two different root-headers of the same version are signed by the keys authorized for the site.
+type Equivocation struct {
+	A, B     Header   // conflicting root-headers (A.Hash() < B.Hash())
+	KeyChain []Header // records of the site key chain older than the version (if the site key was rotated)
+}
+
+func newEquivocation(a, b Header, chain []Header) *Equivocation {
+	if bytes.Compare(a.Hash(), b.Hash()) > 0 {
+		a, b = b, a
+	}
+	e := &Equivocation{A: a.Copy(), B: b.Copy()}
+	for _, h := range chain {
+		if h.Ver() < a.Ver() {
+			e.KeyChain = append(e.KeyChain, h)
+		}
+	}
+	return e
+}
+
+// Ver returns the forked version of the site
+func (e *Equivocation) Ver() int64 {
+	return e.A.Ver()
+}
+
+// Verify verifies the proof of equivocation of the site with the public key
+func (e *Equivocation) Verify(site crypto.PublicKey) (err error) {
+	defer catch(&err)
+
+	a, b := e.A, e.B
+	if a.Path() != "/" || b.Path() != "/" ||
+		a.Ver() <= 0 || a.Ver() != b.Ver() ||
+		bytes.Compare(a.Hash(), b.Hash()) >= 0 {
+		return errInvalidEquivocation
+	}
+	for _, h := range e.KeyChain {
+		if h.Ver() >= a.Ver() {
+			return errInvalidEquivocation
+		}
+	}
+	owner, last, err := verifyKeyChain(site, e.KeyChain)
+	if err != nil {
+		return err
+	}
+	if len(VerifyHeaders(a, b)) > 0 {
+		return errInvalidEquivocation
+	}
+	for _, h := range []Header{a, b} {
+		if !isAuthorizedRoot(site, owner, last, h) {
+			return errInvalidEquivocation
+		}
+	}
+	return nil
+}
+
+// isAuthorizedRoot says the root-header is signed by the owner, the next key or the delegate of the owner
+func isAuthorizedRoot(site, owner crypto.PublicKey, last, h Header) bool {
+	_, err := authorizedPrefix(site, owner, last, h, time.Time{})
+	return err == nil
+}
+
+// Equivocations returns proofs of the site forks seen by the VFS
+func (f *fileSystem) Equivocations() (ee []*Equivocation, err error) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+
+	err = db.GetJSON(f.db, dbKeyEquivocations, &ee)
+	return
+}
+
+// detectEquivocations compares root-headers of the commit with the local history of the site.
+// Proofs of the found forks are saved to the storage even if the commit is rejected,
+// so only the first proof of each version is kept and the count of proofs is bounded by maxEquivocations.
+func (f *fileSystem) detectEquivocations(commit *Commit) {
+	r := f.root()
+	var candidates []Header
+	minVer := r.Ver()
+	for _, h := range append(commit.History, commit.Root()) {
+		if h.Path() == "/" && h.Ver() > 0 && h.Ver() <= r.Ver() {
+			candidates = append(candidates, h)
+			if h.Ver() < minVer {
+				minVer = h.Ver()
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	local := map[int64]Header{}
+	for _, h := range append(f.history(minVer-1), r) {
+		local[h.Ver()] = h
+	}
+	chain := f.mergeKeyChain(commit.KeyChain, r.Ver()+1)
+	var ee []*Equivocation
+	for _, h := range candidates {
+		if x := local[h.Ver()]; x != nil && !bytes.Equal(x.Hash(), h.Hash()) {
+			if e := newEquivocation(x, h, chain); e.Verify(f.pub) == nil {
+				ee = append(ee, e)
+			}
+		}
+	}
+	if len(ee) == 0 {
+		return
+	}
+	var saved []*Equivocation
+	try(db.GetJSON(f.db, dbKeyEquivocations, &saved))
+	n := len(saved)
+	for _, e := range ee {
+		if len(saved) < maxEquivocations && !containsEquivocation(saved, e.Ver()) {
+			saved = append(saved, e)
+		}
+	}
+	if len(saved) > n {
+		try(f.db.Execute(func(tx db.Transaction) error {
+			return db.PutJSON(tx, dbKeyEquivocations, saved)
+		}))
+	}
+}
+
+// containsEquivocation says the list contains the proof of the fork of the version
+func containsEquivocation(ee []*Equivocation, ver int64) bool {
+	for _, e := range ee {
+		if e.Ver() == ver {
+			return true
+		}
+	}
+	return false
+}
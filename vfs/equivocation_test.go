@@ -0,0 +1,118 @@
+package vfs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func makeTestConflictCommits() (a, b *Commit) {
+	a = makeTestCommit(newMemVFS(), "commit1")
+	b = makeTestCommit(newMemVFS(), "commit1")
+	b.Headers[0].Add("X", "x")
+	b.Headers[0].Sign(testPrv)
+	if VersionIsGreater(a.Root(), b.Root()) {
+		a, b = b, a
+	}
+	return
+}
+
+func TestFileSystem_Equivocations(t *testing.T) {
+	commitA, commitB := makeTestConflictCommits()
+	s := newMemVFS()
+	try(s.Commit(commitA))
+
+	ee, err := s.Equivocations()
+	assert(t, err == nil)
+	assert(t, len(ee) == 0)
+
+	// fork is accepted and recorded
+	err = s.Commit(commitB)
+	assert(t, err == nil)
+	ee, err = s.Equivocations()
+	assert(t, err == nil)
+	assert(t, len(ee) == 1)
+	assert(t, ee[0].Ver() == 1)
+	assert(t, ee[0].Verify(testPub) == nil)
+
+	// fork is rejected, the proof is not duplicated
+	err = s.Commit(commitA)
+	assert(t, err != nil)
+	assert(t, len(tryVal(s.Equivocations())) == 1)
+
+	// one proof of the version is kept
+	commitC := makeTestCommit(newMemVFS(), "commit1")
+	commitC.Headers[0].Add("X", "y")
+	commitC.Headers[0].Sign(testPrv)
+	s.Commit(commitC)
+	assert(t, len(tryVal(s.Equivocations())) == 1)
+}
+
+func TestFileSystem_Equivocations_history(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1")
+	fork := newMemVFS()
+	try(fork.Commit(tryVal(s.GetCommit(0))))
+	applyCommit(s, "commit2")
+	applyCommit(fork, "commit3", "commit3")
+
+	// the commit rewriting history is rejected, but version 2 of its history is the proof of the fork
+	err := s.Commit(tryVal(fork.GetCommit(0)))
+	assert(t, err != nil)
+
+	ee := tryVal(s.Equivocations())
+	assert(t, len(ee) == 1)
+	assert(t, ee[0].Ver() == 2)
+	assert(t, ee[0].Verify(testPub) == nil)
+}
+
+func TestEquivocation_Verify(t *testing.T) {
+	commitA, commitB := makeTestConflictCommits()
+	e := newEquivocation(commitB.Root(), commitA.Root(), nil)
+	assert(t, e.Verify(testPub) == nil)
+
+	// portable
+	var e1 *Equivocation
+	try(json.Unmarshal([]byte(toJSON(e)), &e1))
+	assert(t, e1.Verify(testPub) == nil)
+
+	// another site
+	assert(t, e.Verify(testPrv.SubKey("other").PublicKey()) != nil)
+
+	// the same header
+	assert(t, newEquivocation(commitA.Root(), commitA.Root(), nil).Verify(testPub) != nil)
+
+	// different versions
+	commitC := makeTestCommit(applyCommit(newMemVFS(), "commit1"), "commit2")
+	assert(t, newEquivocation(commitA.Root(), commitC.Root(), nil).Verify(testPub) != nil)
+
+	// not authorized signer
+	h := commitB.Root().Copy()
+	h.Sign(testPrv.SubKey("other"))
+	assert(t, newEquivocation(commitA.Root(), h, nil).Verify(testPub) != nil)
+
+	// not signed
+	h = commitB.Root().Copy()
+	h.Set("Y", "y")
+	assert(t, newEquivocation(commitA.Root(), h, nil).Verify(testPub) != nil)
+}
+
+func TestFileSystem_Equivocations_rotatedKey(t *testing.T) {
+	key1 := testPrv.SubKey("key1")
+	s := newMemVFS()
+	try(s.Commit(makeTestRotationCommit(s, testPrv, key1.PublicKey(), "commit1")))
+	try(s.Commit(makeTestRotationCommit(s, key1, nil, "commit2")))
+	replica := newMemVFS()
+	try(replica.Commit(tryVal(s.GetCommit(0))))
+
+	// the new owner signs two different versions 3
+	try(s.Commit(makeTestCommitBy(s, key1, "commit3")))
+	try(replica.Commit(makeTestCommitBy(replica, key1, "commit2")))
+	s.Commit(tryVal(replica.GetCommit(2))) // the fork is recorded whether the commit is accepted or not
+
+	ee := tryVal(s.Equivocations())
+	assert(t, len(ee) == 1)
+	assert(t, len(ee[0].KeyChain) == 2)
+	assert(t, ee[0].Verify(testPub) == nil)
+
+	ee[0].KeyChain = nil
+	assert(t, ee[0].Verify(testPub) != nil)
+}
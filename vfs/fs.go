@@ -5,7 +5,10 @@ import (
 	"github.com/denisskin/dweb/crypto"
 	"github.com/denisskin/dweb/db"
 	"io"
+	"io/fs"
+	"strings"
 	"sync"
+	"time"
 )
 
 type fileSystem struct {
@@ -13,16 +16,54 @@ type fileSystem struct {
 	db    db.Storage
 	mx    sync.RWMutex
 	nodes map[string]*fsNode
+	keys  []Header             // key chain of the site (see verifyKeyChain)
+	bases []*DelegatedBase     // bases of the delegated versions of the site (see delegatedBases)
+	owner crypto.PublicKey     // current owner key of the site
+	blobs *BlobStore           // store of file contents
+	alg   crypto.HashAlgorithm // hash algorithm of the new site
+
+	partsMx sync.Mutex
+	parts   map[string][][]byte // cache of file-part hashes (by BlobID)
+
+	revocations RevocationStore
+	received    time.Time          // local time when the current version was received
+	notaries    []crypto.PublicKey // trusted notaries (see WithTrustedNotaries)
 }
 
 const dbKeyHeaders = "."
 
-func OpenVFS(pub crypto.PublicKey, db db.Storage) (_ VFS, err error) {
+// Option configures VFS opened by OpenVFS
+type Option func(*fileSystem)
+
+// WithRevocationStore sets local store of key revocations.
+// Commits signed by revoked keys and received (or updated) after the revocation time are refused;
+// the content of such versions is not served.
+func WithRevocationStore(rs RevocationStore) Option {
+	return func(f *fileSystem) {
+		f.revocations = rs
+	}
+}
+
+// WithHashAlgorithm sets hash algorithm of the new site (SHA-256 by default).
+// It is ignored if the site already exists in the storage.
+func WithHashAlgorithm(alg crypto.HashAlgorithm) Option {
+	return func(f *fileSystem) {
+		f.alg = alg
+	}
+}
+
+func OpenVFS(pub crypto.PublicKey, db db.Storage, opts ...Option) (_ VFS, err error) {
 	defer catch(&err)
 	s := &fileSystem{
 		pub: pub,
 		db:  db,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.blobs == nil {
+		s.blobs = NewBlobStore(db)
+	}
 	s.initDB()
 	return s, nil
 }
@@ -51,9 +92,19 @@ func (f *fileSystem) initDB() {
 	var hh []Header
 	try(db.GetJSON(f.db, dbKeyHeaders, &hh))
 	if hh == nil { // empty db
-		hh = []Header{NewRootHeader(f.pub)}
+		root := NewRootHeader(f.pub)
+		if f.alg != "" {
+			require(f.alg.IsSupported(), "unsupported Hash-Algorithm")
+			root.SetHashAlgorithm(f.alg)
+		}
+		hh = []Header{root}
 	}
 	f.nodes = tryVal(indexTree(hh))
+	try(db.GetJSON(f.db, dbKeyKeyChain, &f.keys))
+	f.owner, _ = tryVal2(verifyKeyChain(f.pub, f.keys))
+	try(db.GetJSON(f.db, dbKeyDelegatedBases, &f.bases))
+	try(db.GetJSON(f.db, dbKeyReceived, &f.received))
+	f.migrateContents()
 }
 
 func (f *fileSystem) fileHeader(path string) Header {
@@ -67,10 +118,21 @@ func (f *fileSystem) root() Header {
 	return f.nodes["/"].Header
 }
 
+func (f *fileSystem) hashAlg() crypto.HashAlgorithm {
+	return f.nodes["/"].alg
+}
+
 func (f *fileSystem) FileHeader(path string) (Header, error) {
 	f.mx.RLock()
 	defer f.mx.RUnlock()
 
+	if err := f.verifyNotRevoked(); err != nil {
+		return nil, err
+	}
+	return f.copyFileHeader(path)
+}
+
+func (f *fileSystem) copyFileHeader(path string) (Header, error) {
 	if h := f.fileHeader(path); h != nil {
 		return h.Copy(), nil
 	}
@@ -81,15 +143,49 @@ func (f *fileSystem) FileMerkleWitness(path string) (hash, witness []byte, err e
 	f.mx.RLock()
 	defer f.mx.RUnlock()
 
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
 	if f.nodes[path] == nil {
 		return nil, nil, ErrNotFound
 	}
 	witness = f.nodes["/"].childrenMerkleWitness(path)
-	return witness[:crypto.HashSize], witness[crypto.HashSize:], nil
+	n := f.hashAlg().Size()
+	return witness[:n], witness[n:], nil
+}
+
+func (f *fileSystem) FilesMerkleMultiProof(paths []string) (hh []Header, proof *crypto.MerkleMultiProof, err error) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
+	in := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if path == "/" || f.nodes[path] == nil {
+			return nil, nil, ErrNotFound
+		}
+		in[path] = true
+	}
+	if len(in) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	proof = &crypto.MerkleMultiProof{}
+	f.nodes["/"].appendChildrenMultiProof(proof, in, subtreesOf(in), &hh)
+	return
+}
+
+func (f *fileSystem) filePartSize(h Header) int64 {
+	return partSizeOf(f.root(), h)
 }
 
-func (f *fileSystem) rootPartSize() int64 {
-	if size := f.root().PartSize(); size > 0 {
+// partSizeOf returns part size of file h in the tree with the root-header
+func partSizeOf(root, h Header) int64 {
+	if size := h.PartSize(); size > 0 {
+		return size
+	}
+	if size := root.PartSize(); size > 0 {
 		return size
 	}
 	return DefaultFilePartSize
@@ -99,33 +195,102 @@ func (f *fileSystem) FileParts(path string) (hashes [][]byte, err error) {
 	f.mx.RLock()
 	defer f.mx.RUnlock()
 
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
+	hashes, err = f.fileParts(path)
+	return append([][]byte(nil), hashes...), err
+}
+
+// maxCachedFileParts is the max count of files which part hashes are cached
+const maxCachedFileParts = 256
+
+func (f *fileSystem) fileParts(path string) (hashes [][]byte, err error) {
 	h := f.fileHeader(path)
 	if h == nil {
 		err = ErrNotFound
 		return
 	}
-	fl, err := f.db.Open(path)
+	partSize := f.filePartSize(h)
+	key := BlobID(f.root(), h)
+	f.partsMx.Lock()
+	hashes = f.parts[key]
+	f.partsMx.Unlock()
+	if hashes != nil {
+		return
+	}
+	fl, err := f.openContent(f.root(), h)
 	if err != nil {
 		return
 	}
 	defer fl.Close()
 
-	partSize := h.PartSize()
-	if partSize == 0 {
-		partSize = f.rootPartSize()
+	w := f.hashAlg().NewParallelMerkleHash(partSize, 0)
+	if _, err = io.Copy(w, fl); err != nil {
+		return
 	}
-	w := crypto.NewMerkleHash(partSize)
-	_, err = io.Copy(w, fl)
 	hashes = w.Leaves()
+
+	f.partsMx.Lock()
+	defer f.partsMx.Unlock()
+	if f.parts == nil || len(f.parts) >= maxCachedFileParts {
+		f.parts = map[string][][]byte{}
+	}
+	f.parts[key] = hashes
 	return
 }
 
+func (f *fileSystem) FilePartWitness(path string, part int) (hash, witness []byte, err error) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
+	hashes, err := f.fileParts(path)
+	if err != nil {
+		return
+	}
+	if part < 0 || part >= len(hashes) {
+		return nil, nil, ErrNotFound
+	}
+	witness = f.hashAlg().MakeMerkleWitness(hashes, part)
+	n := f.hashAlg().Size()
+	return witness[:n], witness[n:], nil
+}
+
 func (f *fileSystem) Open(path string) (io.ReadSeekCloser, error) {
-	return f.db.Open(path)
+	f.mx.RLock()
+	err := f.verifyNotRevoked()
+	h, root := f.fileHeader(path), f.root()
+	f.mx.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, ErrNotFound
+	}
+	return f.openContent(root, h)
+}
+
+// openContent opens content of the file h of the tree with the root-header (dirs and files without content are read as empty)
+func (f *fileSystem) openContent(root, h Header) (io.ReadSeekCloser, error) {
+	if len(h.FileMerkle()) == 0 {
+		return nopCloser{bytes.NewReader(nil)}, nil
+	}
+	return f.blobs.Open(BlobID(root, h))
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
 }
 
 func (f *fileSystem) OpenAt(path string, offset int64) (io.ReadCloser, error) {
-	r, err := f.db.Open(path)
+	r, err := f.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -147,12 +312,42 @@ func (f *fileSystem) ReadDir(path string) ([]Header, error) {
 	f.mx.RLock()
 	defer f.mx.RUnlock()
 
+	if err := f.verifyNotRevoked(); err != nil {
+		return nil, err
+	}
+	return f.readDir(path)
+}
+
+func (f *fileSystem) readDir(path string) ([]Header, error) {
 	if d := f.nodes[path]; d != nil && d.isDir() && !d.deleted() {
 		return d.copyChildHeaders(), nil
 	}
 	return nil, ErrNotFound
 }
 
+// fsTree is the view of the current tree of the file system that is not gated by revocations (see commitSourcer)
+type fsTree fileSystem
+
+func (f *fileSystem) commitSource(src fs.FS) (*commitSource, error) {
+	return &commitSource{tree: (*fsTree)(f), files: src}, nil
+}
+
+func (t *fsTree) FileHeader(path string) (Header, error) {
+	f := (*fileSystem)(t)
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+
+	return f.copyFileHeader(path)
+}
+
+func (t *fsTree) ReadDir(path string) ([]Header, error) {
+	f := (*fileSystem)(t)
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+
+	return f.readDir(path)
+}
+
 func (f *fileSystem) Get(req string) (commit *Commit, err error) {
 	f.mx.RLock()
 	defer f.mx.RUnlock()
@@ -165,20 +360,36 @@ func (f *fileSystem) GetCommit(ver int64) (commit *Commit, err error) {
 	defer f.mx.RUnlock()
 	defer catch(&err)
 
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
 	root := f.nodes["/"]
 	if root.Header.Ver() <= ver {
 		return
 	}
 	w := newFilesReader()
 	commit = &Commit{Body: w}
+	for _, h := range f.keys {
+		if h.Ver() > ver {
+			commit.KeyChain = append(commit.KeyChain, h.Copy())
+		}
+	}
+	commit.History = f.history(ver)
+	for _, b := range f.bases {
+		if b.Ver >= ver {
+			commit.Bases = append(commit.Bases, b)
+		}
+	}
+	commit.Attestations = append(f.commitAttestations(commit.History), f.attestations(root.Header.Hash())...)
 	root.walk(func(nd *fsNode) bool {
 		if h := nd.Header; h.Ver() > ver {
 			commit.Headers = append(commit.Headers, h.Copy())
 
 			// TODO: rr[] = f.getReader(path) ...;  commit.Body = io.MultiReader(rr...)
 			if size := h.FileSize(); size > 0 { // write file content to commit-body
+				id := BlobID(root.Header, h)
 				w.add(func() (io.ReadCloser, error) {
-					return f.Open(nd.path)
+					return f.blobs.Open(id)
 				})
 			}
 		}
@@ -200,38 +411,83 @@ func (f *fileSystem) Commit(commit *Commit) (err error) {
 	r := f.root()
 	b := commit.Root()
 
-	require(b.Get(headerProtocol) == DefaultProtocol, "unsupported Protocol")
+	require(b.Protocol() == DefaultProtocol || b.Protocol() == protocol01, "unsupported Protocol")
+	require(b.Protocol() == r.Protocol() || b.Protocol() == DefaultProtocol || r.Ver() == 0, "invalid commit-header Protocol")
 	try(ValidateHeader(b))
 	require(b.Path() == "/", "invalid commit-header Path")
 	require(b.Ver() > 0, "invalid commit-header Ver")
 	require(b.PartSize() == r.PartSize(), "invalid commit-header Part-Size")
+	require(b.HashAlgorithm().IsSupported(), "unsupported commit-header Hash-Algorithm")
+	require(b.HashAlgorithm() == r.HashAlgorithm() || r.Ver() == 0, "invalid commit-header Hash-Algorithm")
 	require(!b.Created().IsZero(), "invalid commit-header Created")
 	require(!b.Updated().IsZero(), "invalid commit-header Updated")
 	require(b.Created().Equal(r.Created()) || r.Created().IsZero(), "invalid commit-header Created")
 	require(!b.Updated().Before(b.Created()), "invalid commit-header Updated")
+	f.detectEquivocations(commit) // conflicting commits are recorded before they are rejected
 	require(VersionIsGreater(b, r), "invalid commit-header Ver")
+	require(b.Ver() > r.Ver() || !f.isKeyChainRecord(r), "key chain record can not be replaced")
 	require(!b.Deleted(), "invalid commit-header Deleted")
-	require(b.PublicKey().Equal(f.pub), "invalid commit-header Public-Key")
+	chain := f.mergeKeyChain(commit.KeyChain, b.Ver())
+	owner, last := tryVal2(verifyKeyChain(f.pub, chain))
+	lineage, prefixes := tryVal2(verifyLineage(f.pub, chain, r, b, commit.History, func(h Header) time.Time {
+		return f.attestedTime(h.Hash(), commit.Attestations)
+	}))
+	prefix := "/"       // path prefix of files the commit signer is authorized for
+	keyChanged := false // commit changes Next-Key-Hash
+	newOwner := owner
+	switch {
+	case b.PublicKey().Equal(owner):
+		require(!b.Has(headerDelegation), "invalid commit-header Delegation")
+		if len(last.NextKeyHash()) == 0 && len(b.NextKeyHash()) > 0 { // the first commitment to next key
+			chain, keyChanged = append(chain, b), true
+		}
+	case last.isNextKey(b.PublicKey()): // commit is signed by the next key; rotate ownership
+		require(!b.Has(headerDelegation), "invalid commit-header Delegation")
+		chain, keyChanged, newOwner = append(chain, b), true, b.PublicKey()
+	default: // commit is signed by delegated key; it can contain only the headers under the delegated prefix
+		prefix = prefixes[len(prefixes)-1]
+		require(b.Ver() > r.Ver(), "invalid commit-header Ver")
+		require(!b.Updated().Before(r.Updated()), "invalid commit-header Updated")
+		require(rootFieldsEqual(b, r), "invalid commit-header")
+	}
+	if !keyChanged { // Next-Key-Hash can be changed by rotation commit only
+		require(bytes.Equal(b.NextKeyHash(), last.NextKeyHash()), "invalid commit-header Next-Key-Hash")
+	}
 	require(b.Verify(), "invalid commit-header Signature")
+	received := time.Now()
+	try(verifyNotRevoked(f.revocations, owner, b, f.receivedTime(b, received, commit.Attestations)))
+	attestations := map[string][]*crypto.Attestation{} // notary attestations of the new versions
+	for _, a := range commit.Attestations {
+		if !f.isTrustedNotary(a.Notary) { // attestations of other notaries are skipped
+			continue
+		}
+		for _, h := range lineage {
+			if hash := h.Hash(); bytes.Equal(a.Hash, hash) {
+				require(a.Verify(hash), "invalid commit attestation")
+				key := attestationsKey(hash)
+				if _, ok := attestations[key]; !ok {
+					attestations[key] = f.attestations(hash)
+				}
+				attestations[key], _ = mergeAttestation(attestations[key], a)
+			}
+		}
+	}
 
 	//-----------
 	curTree := f.nodes
-	delFiles := map[string]bool{} // files to delete
-	if b.Ver() == r.Ver() {       // if versions are equal than truncate db
+	if b.Ver() == r.Ver() { // if versions are equal than truncate db
 		curTree = map[string]*fsNode{}
-		for _, nd := range f.nodes {
-			if !nd.isDir() && nd.Header.FileSize() > 0 {
-				delFiles[nd.path] = true
-			}
-		}
 	}
 
 	//--- verify other headers ---
+	alg := b.HashAlgorithm()
 	updated := make(map[string]Header, len(commit.Headers))
 	hh := make([]Header, 0, len(commit.Headers)+len(curTree))
+	outOfPrefix := false // commit contains headers out of the delegated prefix
 	for _, h := range commit.Headers {
 		try(ValidateHeader(h))
 		path := h.Path()
+		outOfPrefix = outOfPrefix || path != "/" && !strings.HasPrefix(path, prefix)
 		hh = append(hh, h)
 		updated[path] = h
 
@@ -240,16 +496,9 @@ func (f *fileSystem) Commit(commit *Commit) (err error) {
 			require(!h.Has(headerFileMerkle), "invalid commit-header")
 			require(!h.Has(headerFileSize), "invalid commit-header")
 		} else { // is not deleted file
-			require(h.FileSize() == 0 && !h.Has(headerFileMerkle) || h.FileSize() > 0 && len(h.FileMerkle()) == crypto.HashSize, "invalid commit-header")
+			require(h.FileSize() == 0 && !h.Has(headerFileMerkle) || h.FileSize() > 0 && len(h.FileMerkle()) == alg.Size(), "invalid commit-header")
 		}
-		if h.Deleted() { // delete all sub-files
-			curTree[path].walk(func(nd *fsNode) bool {
-				if !nd.isDir() && nd.Header.FileSize() > 0 {
-					delFiles[nd.path] = true
-				}
-				return true
-			})
-		} else { // can`t restore deleted node
+		if !h.Deleted() { // can`t restore deleted node
 			nd := curTree[path]
 			require(nd == nil || !nd.Header.Deleted(), "invalid commit-header")
 		}
@@ -282,69 +531,64 @@ func (f *fileSystem) Commit(commit *Commit) (err error) {
 	require(totalVolume == b.GetInt(headerTreeVolume), "invalid commit-header Volume")
 	require(bytes.Equal(newMerkle, b.TreeMerkleRoot()), "invalid commit-header Merkle-Root")
 
-	rootPartSize := b.PartSize()
-	//if rootPartSize == 0 {
-	//	rootPartSize = DefaultFilePartSize
-	//}
+	//--- verify files out of the delegated prefix by the trees of the previous versions
+	bases, verified := tryVal2(f.delegatedBases(commit, newTree, lineage, prefixes))
+	require(verified || !outOfPrefix, "commit-header Path is out of delegated prefix")
 
-	//--- verify and put file content
-	try(f.db.Execute(func(tx db.Transaction) (err error) {
+	//--- verify and put file content by Merkle root (content of removed files is released after saving the tree)
+	added, removed := diffBlobRefs(f.nodes, newTree)
+	try(f.blobs.put(alg, commit.Headers, commit.Body, b.PartSize(), added))
+
+	//--- save to Storage
+	err = f.db.Execute(func(tx db.Transaction) (err error) {
 		defer catch(&err)
-		for _, h := range commit.Headers {
-			if hSize, hMerkle := h.FileSize(), h.FileMerkle(); hSize > 0 || len(hMerkle) != 0 {
-				partSize := h.PartSize()
-				if partSize == 0 {
-					partSize = rootPartSize
-				}
-				require(partSize > 0, "empty commit-header Part-Size")
-
-				r := io.LimitReader(commit.Body, hSize)
-				w := crypto.NewMerkleHash(partSize)
-				try(tx.Put(h.Path(), io.TeeReader(r, w)))
-				require(w.Written() == hSize, "invalid commit-content")
-				require(bytes.Equal(w.Root(), h.FileMerkle()), "invalid commit-header Merkle")
-				delete(delFiles, h.Path())
-
-				//-------- v0
-				//cont := make([]byte, int(hSize))
-				//n, err := io.ReadFull(commit.Body, cont)
-				//try(err)
-				//require(int64(n) == hSize, "invalid commit-content")
-				//
-				//merkle, _, _ := crypto.ReadMerkleRoot(bytes.NewBuffer(cont), hSize, partSize)
-				////require(hSize == sz, "invalid commit-header Size")
-				//require(bytes.Equal(h.FileMerkle(), merkle), "invalid commit-header Merkle")
-				//
-				//err = tx.Put(h.Path(), bytes.NewBuffer(cont))
-				//try(err)
-				//delete(delFiles, h.Path())
-
-				//-----------
-				// TODO: r := crypto.NewMerkleReader(commit.Body, hSize, h.PartSize())
-				// tx.Put(key, r) // put reader
-				// require(bytes.Equal(h.Merkle(), r.MerkleRoot()))
-				// require(r.ReadSize() == size, "invalid commit-header Size")
-
-				// todo: put content by hash (put if not exists, delete on error)
-				//key:=fmt.Sprintf("X%x", merkle[:16])
-				//exst, err:= f.db.Exists(key)
-				//try(err)
-				//if !exst {
-				//	err = f.db.Put(key, bytes.NewBuffer(cont))
-				//	try(err)
-				//}
-			}
+		try(db.PutJSON(tx, dbKeyHeaders, hh))
+		for _, h := range lineage {
+			try(db.PutJSON(tx, historyKey(h.Hash()), h))
 		}
-
-		//--- delete old files (???) -----
-		for path := range delFiles {
-			try(tx.Delete(path))
+		for key, aa := range attestations {
+			try(db.PutJSON(tx, key, aa))
 		}
-		//--- save to Storage
-		try(db.PutJSON(tx, dbKeyHeaders, hh))
+		if toJSON(chain) != toJSON(f.keys) {
+			try(db.PutJSON(tx, dbKeyKeyChain, chain))
+		}
+		if toJSON(bases) != toJSON(f.bases) {
+			try(db.PutJSON(tx, dbKeyDelegatedBases, bases))
+		}
+		try(db.PutJSON(tx, dbKeyReceived, received))
 		return
-	}))
-
+	})
+	if err != nil {
+		f.blobs.release(added)
+		return
+	}
 	f.nodes = newTree
+	f.keys = chain
+	f.owner = newOwner
+	f.bases = bases
+	f.received = received
+
+	f.blobs.release(removed) // if it fails, content of removed files is only kept by excess references
+	return
+}
+
+// isKeyChainRecord says the root-header is the last record of the local key chain (rotation or the first commitment)
+func (f *fileSystem) isKeyChainRecord(h Header) bool {
+	n := len(f.keys)
+	return n > 0 && bytes.Equal(f.keys[n-1].Hash(), h.Hash())
+}
+
+// mergeKeyChain returns local key chain appended by the newer records of the commit key chain
+func (f *fileSystem) mergeKeyChain(cc []Header, ver int64) (chain []Header) {
+	for _, h := range f.keys {
+		if h.Ver() < ver { // records of the conflicting commit of the same version are replaced
+			chain = append(chain, h)
+		}
+	}
+	for _, h := range cc {
+		if h.Ver() < ver && (len(chain) == 0 || h.Ver() > chain[len(chain)-1].Ver()) {
+			chain = append(chain, h)
+		}
+	}
 	return
 }
@@ -9,7 +9,10 @@ import (
 type fsNode struct {
 	Header   Header
 	path     string
+	alg      crypto.HashAlgorithm
+	proto    string // Protocol of the root-header (defines the header hashing rule)
 	children []*fsNode
+	merkle   []byte // Merkle root of the replaced subtree (see prunedTree)
 }
 
 var (
@@ -28,6 +31,7 @@ func indexTree(hh []Header) (tree map[string]*fsNode, err error) {
 		nd := &fsNode{Header: h, path: path}
 		tree[path] = nd
 		if path == "/" {
+			nd.alg, nd.proto = h.HashAlgorithm(), h.Protocol()
 			continue
 		}
 		if p := tree[dirname(path)]; p == nil { // find parent node
@@ -35,6 +39,7 @@ func indexTree(hh []Header) (tree map[string]*fsNode, err error) {
 		} else if p.Header.Deleted() {
 			return nil, errParentDirIsDeleted
 		} else {
+			nd.alg, nd.proto = p.alg, p.proto
 			p.children = append(p.children, nd)
 		}
 	}
@@ -69,27 +74,34 @@ func (nd *fsNode) hasFile(path string) bool {
 	return nd.path == path || nd.isDir() && strings.HasPrefix(path, nd.path)
 }
 
+func (nd *fsNode) hash() []byte {
+	return nd.Header.HashBy(nd.alg, nd.proto)
+}
+
 func (nd *fsNode) merkleRoot() []byte {
+	if nd.merkle != nil {
+		return nd.merkle
+	}
 	if len(nd.children) == 0 {
-		return nd.Header.Hash()
+		return nd.hash()
 	}
-	return crypto.MerkleRoot(nd.Header.Hash(), nd.childrenMerkleRoot())
+	return nd.alg.MerkleRoot(nd.hash(), nd.childrenMerkleRoot())
 }
 
 func (nd *fsNode) merkleWitness(path string) []byte {
 	if nd.path == path {
 		if len(nd.children) == 0 { // is file or empty dir
-			return nd.Header.Hash()
+			return nd.hash()
 		}
-		return crypto.MakeMerkleWitness([][]byte{ // is dir
-			nd.Header.Hash(),
+		return nd.alg.MakeMerkleWitness([][]byte{ // is dir
+			nd.hash(),
 			nd.childrenMerkleRoot(),
 		}, 0)
 	}
 	return crypto.MerkleWitnessAppend(
 		nd.childrenMerkleWitness(path),
 		crypto.OpLHash,
-		nd.Header.Hash(),
+		nd.hash(),
 	)
 }
 
@@ -104,7 +116,7 @@ func (nd *fsNode) totalVolume() (n int64) {
 }
 
 func (nd *fsNode) childrenMerkleRoot() []byte {
-	return crypto.MakeMerkleRoot(len(nd.children), func(i int) []byte {
+	return nd.alg.MakeMerkleRoot(len(nd.children), func(i int) []byte {
 		return nd.children[i].merkleRoot()
 	})
 }
@@ -120,5 +132,43 @@ func (nd *fsNode) childrenMerkleWitness(path string) []byte {
 			hashes = append(hashes, sub.merkleRoot())
 		}
 	}
-	return crypto.MakeMerkleWitness(hashes, iHash)
+	return nd.alg.MakeMerkleWitness(hashes, iHash)
+}
+
+// subtreesOf returns paths of the given files and of all their parent dirs
+func subtreesOf(paths map[string]bool) map[string]bool {
+	has := make(map[string]bool, len(paths))
+	for path := range paths {
+		for ; path != "" && !has[path]; path = dirname(path) {
+			has[path] = true
+		}
+	}
+	return has
+}
+
+// appendMultiProof appends merkle multi-proof of the given files to p and their headers to hh.
+// has contains paths of the subtrees with the given files (see subtreesOf).
+func (nd *fsNode) appendMultiProof(p *crypto.MerkleMultiProof, paths, has map[string]bool, hh *[]Header) {
+	if !has[nd.path] {
+		p.AppendHash(nd.merkleRoot())
+		return
+	}
+	if paths[nd.path] {
+		p.AppendLeaf()
+		*hh = append(*hh, nd.Header.Copy())
+	} else {
+		p.AppendHash(nd.hash())
+	}
+	if len(nd.children) > 0 { // is dir
+		nd.appendChildrenMultiProof(p, paths, has, hh)
+		p.AppendJoin()
+	}
+}
+
+func (nd *fsNode) appendChildrenMultiProof(p *crypto.MerkleMultiProof, paths, has map[string]bool, hh *[]Header) {
+	p.AppendTree(nd.alg, len(nd.children),
+		func(i int) bool { return has[nd.children[i].path] },
+		func(i int) { nd.children[i].appendMultiProof(p, paths, has, hh) },
+		func(i int) []byte { return nd.children[i].merkleRoot() },
+	)
 }
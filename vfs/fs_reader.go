@@ -18,7 +18,7 @@ func (f *filesReader) add(fn fileOpenFunc) {
 }
 
 func (f *filesReader) Read(buf []byte) (n int, err error) {
-	for len(buf) > 0 && len(f.ff) > 0 {
+	for len(buf) > 0 && (f.r != nil || len(f.ff) > 0) {
 		if f.r == nil {
 			if f.r, err = f.ff[0](); err != nil {
 				return n, err
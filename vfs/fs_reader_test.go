@@ -0,0 +1,22 @@
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFilesReader(t *testing.T) {
+	r := newFilesReader()
+	for _, s := range []string{"abc", "", "defgh"} {
+		s := s
+		r.add(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewBufferString(s)), nil
+		})
+	}
+
+	data, err := io.ReadAll(iotest.OneByteReader(r))
+	assert(t, err == nil)
+	assert(t, string(data) == "abcdefgh") // content of the last file is read to the end
+}
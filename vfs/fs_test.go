@@ -2,12 +2,18 @@ package vfs
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/denisskin/dweb/crypto"
 	"github.com/denisskin/dweb/db"
+	"github.com/denisskin/dweb/db/boltdb"
+	"github.com/denisskin/dweb/db/filedb"
 	"github.com/denisskin/dweb/db/memdb"
 	"github.com/denisskin/dweb/vfs/test_data"
 	"io"
+	"io/fs"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -195,7 +201,7 @@ func TestFileSystem_FileMerkleWitness(t *testing.T) {
 		// make merkle witness for each file
 		fileHash, fileWitness, err := s.FileMerkleWitness(h.Path())
 		assert(t, err == nil)
-		assert(t, bytes.Equal(fileHash, h.Hash()))
+		assert(t, bytes.Equal(fileHash, h.HashBy(hh[0].HashAlgorithm(), hh[0].Protocol())))
 		assert(t, len(fileWitness) > 0 && len(fileWitness)%33 == 0)
 		assert(t, 32 == len(fileHash))
 
@@ -211,6 +217,168 @@ func TestFileSystem_FileMerkleWitness(t *testing.T) {
 	}
 }
 
+func TestFileSystem_hashAlgorithm(t *testing.T) {
+	for _, alg := range []crypto.HashAlgorithm{crypto.SHA256, crypto.SHA512_256, crypto.BLAKE3} {
+		s := applyCommit(newMemVFSWithHash(alg), "commit1", "commit2", "commit3")
+		root := fsHeaders(s)[0]
+		assert(t, root.HashAlgorithm() == alg)
+
+		// replicate to empty vfs
+		commit, err := s.GetCommit(0)
+		assert(t, err == nil)
+		s2 := newMemVFS()
+		err = s2.Commit(commit)
+		assert(t, err == nil)
+		assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(s2)))
+
+		// verify merkle witnesses
+		for _, h := range fsHeaders(s2)[1:] {
+			hash, witness, err := s2.FileMerkleWitness(h.Path())
+			assert(t, err == nil)
+			assert(t, bytes.Equal(hash, h.HashBy(alg, root.Protocol())))
+			assert(t, alg.VerifyMerkleWitness(hash, root.TreeMerkleRoot(), witness))
+		}
+	}
+
+	// new site with the chosen hash algorithm
+	s := tryVal(OpenVFS(testPub, memdb.New(), WithHashAlgorithm(crypto.BLAKE3)))
+	applyCommit(s, "commit1")
+	assert(t, fsHeaders(s)[0].HashAlgorithm() == crypto.BLAKE3)
+	_, err := OpenVFS(testPub, memdb.New(), WithHashAlgorithm("bogus"))
+	assert(t, err != nil)
+
+	// can not change hash algorithm of the site
+	s = applyCommit(newMemVFS(), "commit1")
+	commit := makeTestCommit(s, "commit2")
+	commit.Headers[0].SetHashAlgorithm(crypto.BLAKE3)
+	commit.Headers[0].Sign(testPrv)
+	err = s.Commit(commit)
+	assert(t, err != nil)
+}
+
+func TestFileSystem_protocol01(t *testing.T) {
+	// site created by protocol 0.1
+	d := memdb.New()
+	try(d.Execute(func(tx db.Transaction) error {
+		h0 := NewRootHeader(testPub)
+		h0.Set(headerProtocol, protocol01)
+		h0.SetInt(headerPartSize, 1024)
+		h0.SetTime("Created", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+		h0.SetTime("Updated", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+		return db.PutJSON(tx, dbKeyHeaders, []Header{h0})
+	}))
+	s := applyCommit(tryVal(OpenVFS(testPub, d)), "commit1", "commit2")
+
+	hh := fsHeaders(s)
+	alg := hh[0].HashAlgorithm()
+	assert(t, hh[0].Protocol() == protocol01)
+	assert(t, hh[0].Verify())
+	for _, h := range hh[1:] { // file headers are hashed by the rule of protocol 0.1
+		hash, _, err := s.FileMerkleWitness(h.Path())
+		assert(t, err == nil)
+		assert(t, bytes.Equal(hash, h.HashBy(alg, protocol01)))
+		assert(t, !bytes.Equal(hash, h.HashBy(alg, DefaultProtocol)))
+	}
+
+	// replicate to empty vfs
+	s2 := newMemVFS()
+	err := s2.Commit(tryVal(s.GetCommit(0)))
+	assert(t, err == nil)
+	assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(s2)))
+}
+
+func TestMakeMultiSigCommit(t *testing.T) {
+	prv1, prv2, prv3 := testPrv.SubKey("1"), testPrv.SubKey("2"), testPrv.SubKey("3")
+	pub, err := crypto.NewMultiPublicKey(2, prv1.PublicKey(), prv2.PublicKey(), prv3.PublicKey())
+	assert(t, err == nil)
+	s := tryVal(OpenVFS(pub, memdb.New()))
+	ts := time.Now()
+
+	// commit signed by one of signers - FAIL
+	commit, err := MakeMultiSigCommit(s, pub, prv1, test_data.FS("commit1"), ts)
+	assert(t, err == nil)
+	assert(t, commit.Root().SignersCount() == 1)
+	err = s.Commit(commit)
+	assert(t, err != nil)
+
+	// commit signed by single key of signer - FAIL
+	commit1, err := MakeCommit(s, prv1, test_data.FS("commit1"), ts)
+	assert(t, err == nil)
+	err = s.Commit(commit1)
+	assert(t, err != nil)
+
+	// offline signer signs the copy of the root-header (unsigned)
+	h3 := decodeHeader(commit.Root().unsigned().String())
+	err = h3.AddSignature(prv3)
+	assert(t, err == nil)
+	err = h3.AddSignature(testPrv) // not a signer
+	assert(t, err != nil)
+
+	// collect signatures
+	err = commit.Headers[0].MergeSignatures(h3)
+	assert(t, err == nil)
+	assert(t, commit.Root().SignersCount() == 2)
+	assert(t, commit.Root().Verify())
+	err = s.Commit(commit)
+	assert(t, err == nil)
+
+	// signature of another header can not be merged
+	commit, _ = MakeMultiSigCommit(s, pub, prv1, test_data.FS("commit2"), ts.Add(time.Second))
+	err = commit.Headers[0].MergeSignatures(h3)
+	assert(t, err != nil)
+}
+
+type testSigner struct {
+	prv     crypto.PrivateKey
+	digests int
+}
+
+func (s *testSigner) PublicKey() crypto.PublicKey {
+	return s.prv.PublicKey()
+}
+
+func (s *testSigner) SignDigest(digest []byte) ([]byte, error) {
+	s.digests++
+	return s.prv.Sign(digest), nil
+}
+
+func TestMakeCommit_signer(t *testing.T) {
+	s := newMemVFS()
+	signer := &testSigner{prv: testPrv}
+
+	commit, err := MakeCommit(s, signer, test_data.FS("commit1"), time.Now())
+	assert(t, err == nil)
+	assert(t, signer.digests == 1)
+	err = s.Commit(commit)
+	assert(t, err == nil)
+}
+
+func TestMakeUnsignedCommit(t *testing.T) {
+	s := newMemVFS()
+
+	commit, err := MakeUnsignedCommit(s, testPub, test_data.FS("commit1"), time.Now())
+	assert(t, err == nil)
+	err = s.Commit(commit)
+	assert(t, err != nil) // not signed
+
+	// export root-header, sign it on air-gapped machine
+	h := decodeHeader(commit.Root().String())
+	sig := testPrv.Sign(h.SigningDigest())
+
+	// import signature
+	err = commit.SetSignature(testPrv.SubKey("other").Sign(h.SigningDigest()))
+	assert(t, err != nil)
+	err = commit.SetSignature(sig)
+	assert(t, err == nil)
+	err = s.Commit(commit)
+	assert(t, err == nil)
+}
+
+func decodeHeader(s string) (h Header) {
+	try(json.Unmarshal([]byte(s), &h))
+	return
+}
+
 func makeTestCommit(vfs VFS, commitName string) *Commit {
 	hRoot := tryVal(vfs.FileHeader("/"))
 	tCommit := hRoot.Updated().Add(time.Second)
@@ -221,7 +389,11 @@ func fsHeaders(f VFS) (hh []Header) {
 	return f.(*fileSystem).headers()
 }
 
-func newMemVFS() VFS {
+func newMemVFS(opts ...Option) VFS {
+	return newMemVFSWithHash("", opts...)
+}
+
+func newMemVFSWithHash(alg crypto.HashAlgorithm, opts ...Option) VFS {
 	var t0, _ = time.Parse("2006-01-02 15:04:05", "2022-01-01 00:00:00")
 
 	d := memdb.New()
@@ -230,9 +402,12 @@ func newMemVFS() VFS {
 		h0.SetTime("Created", t0)
 		h0.SetTime("Updated", t0)
 		h0.SetInt(headerPartSize, 1024)
+		if alg != "" {
+			h0.SetHashAlgorithm(alg)
+		}
 		return db.PutJSON(tx, dbKeyHeaders, []Header{h0})
 	}))
-	return tryVal(OpenVFS(testPub, d))
+	return tryVal(OpenVFS(testPub, d, opts...))
 }
 
 func applyCommit(f VFS, commitName ...string) VFS {
@@ -241,3 +416,91 @@ func applyCommit(f VFS, commitName ...string) VFS {
 	}
 	return f
 }
+
+func TestFileSystem_FilePartWitness(t *testing.T) {
+	s := newMemVFS()
+	data := bytes.Repeat([]byte("0123456789"), 1000) // 10000 bytes; 10 parts
+	src := fstest.MapFS{"big.txt": {Data: data}}
+	commit, err := MakeCommit(s, testPrv, src, time.Now())
+	assert(t, err == nil)
+	err = s.Commit(commit)
+	assert(t, err == nil)
+
+	h, err := s.FileHeader("/big.txt")
+	assert(t, err == nil)
+	root := fsHeaders(s)[0]
+	partSize := root.PartSize()
+
+	for i := 0; i < 10; i++ {
+		part := data[i*int(partSize):]
+		if len(part) > int(partSize) {
+			part = part[:partSize]
+		}
+		hash, witness, err := s.FilePartWitness("/big.txt", i)
+		assert(t, err == nil)
+		assert(t, bytes.Equal(hash, crypto.Hash(part)))
+
+		assert(t, VerifyFilePart(root, h, i, part, witness))
+		assert(t, !VerifyFilePart(root, h, (i+1)%10, part, witness)) // wrong part index
+	}
+	assert(t, len(s.(*fileSystem).parts) == 1) // part hashes are calculated once
+
+	_, _, err = s.FilePartWitness("/big.txt", 10)
+	assert(t, err == ErrNotFound)
+}
+
+func TestFileSystem_FilesMerkleMultiProof(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1", "commit2")
+	root := fsHeaders(s)[0]
+
+	paths := []string{"/B/1/2.txt", "/A/", "/A/1.txt", "/B/2/c/c.txt", "/main.css"}
+	hh, proof, err := s.FilesMerkleMultiProof(paths)
+	assert(t, err == nil)
+	assert(t, len(hh) == len(paths))
+	assert(t, VerifyFilesMultiProof(root, hh, proof))
+
+	// modify header
+	hh[1] = hh[1].Copy()
+	hh[1].SetInt("Ver", 100)
+	assert(t, !VerifyFilesMultiProof(root, hh, proof))
+
+	// not existed file
+	_, _, err = s.FilesMerkleMultiProof([]string{"/A/1.txt", "/A/100.txt"})
+	assert(t, err == ErrNotFound)
+}
+
+func testReopenVFS(t *testing.T, open func() db.Storage) {
+	s := tryVal(OpenVFS(testPub, open()))
+	applyCommit(s, "commit1", "commit2")
+	root := tryVal(s.FileHeader("/"))
+
+	// reopen
+	s = tryVal(OpenVFS(testPub, open()))
+	assertEq(t, tryVal(s.FileHeader("/")), root)
+	assert(t, len(tryVal(s.History(0))) == 2)
+
+	r := tryVal(s.OpenAt("/A/1.txt", 0))
+	defer r.Close()
+	data := tryVal(io.ReadAll(r))
+	assert(t, bytes.Equal(data, tryVal(fs.ReadFile(test_data.FS("commit2"), "A/1.txt"))))
+}
+
+func TestOpenVFS_fileDB(t *testing.T) {
+	dir := t.TempDir()
+	testReopenVFS(t, func() db.Storage {
+		return tryVal(filedb.Open(dir))
+	})
+}
+
+func TestOpenVFS_boltDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+	var d *boltdb.DB
+	testReopenVFS(t, func() db.Storage {
+		if d != nil {
+			d.Close()
+		}
+		d = tryVal(boltdb.Open(path))
+		return d
+	})
+	d.Close()
+}
@@ -36,11 +36,15 @@ var errInvalidJSON = errors.New("invalid JSON")
 // predefined header-field-names
 const (
 	// root header fields
-	headerProtocol   = "Protocol"    //
-	headerPublicKey  = "Public-Key"  //
-	headerSignature  = "Signature"   //
-	headerTreeVolume = "Volume"      // volume of full file tree
-	headerTreeMerkle = "Merkle-Root" // root merkle of full file tree
+	headerProtocol   = "Protocol"       //
+	headerPublicKey  = "Public-Key"     //
+	headerSignature  = "Signature"      //
+	headerTreeVolume = "Volume"         // volume of full file tree
+	headerTreeMerkle = "Merkle-Root"    // root merkle of full file tree
+	headerHashAlg    = "Hash-Algorithm" // hash algorithm of the site (SHA-256 by default)
+	headerDelegation = "Delegation"     // chain of delegation certificates of the commit signer
+	headerNextKey    = "Next-Key-Hash"  // hash of the next key of the site
+	headerPrev       = "Prev"           // hash of the root-header of the previous version
 
 	// general
 	headerVer     = "Ver"     // file or dir-version
@@ -55,6 +59,7 @@ const (
 	headerPartSize   = "Part-Size" // file part size
 )
 
+// NewRootHeader returns root-header of the new site hashed by the default hash algorithm
 func NewRootHeader(pub crypto.PublicKey) (h Header) {
 	h.Add(headerProtocol, DefaultProtocol)
 	h.Add(headerPath, "/")
@@ -225,14 +230,25 @@ func (h *Header) Delete(key string) {
 	}
 }
 
+// Hash returns hash of the header by the hash algorithm of the header
+// Hash returns hash of the header by its own Hash-Algorithm and Protocol
 func (h Header) Hash() []byte {
+	return h.HashBy(h.HashAlgorithm(), h.Protocol())
+}
+
+// HashBy returns hash of the header by the hash algorithm and the hashing rule of the protocol.
+// Headers of protocol 0.1 (and headers without Protocol) are hashed without the last field before "Signature".
+func (h Header) HashBy(alg crypto.HashAlgorithm, protocol string) []byte {
 	n := len(h)
 	if n > 0 && h[n-1].Name == headerSignature { // exclude last header "Signature"
 		n--
 	}
-	hsh := crypto.NewHash()
+	if n > 0 && (protocol == "" || protocol == protocol01) {
+		n--
+	}
+	hsh := alg.New()
 	buf := make([]byte, 4)
-	for _, kv := range h[:n-1] {
+	for _, kv := range h[:n] {
 		// write <len><Name>
 		binary.BigEndian.PutUint32(buf, uint32(len(kv.Name)))
 		hsh.Write(buf)
@@ -308,6 +324,18 @@ func (h Header) TreeMerkleRoot() []byte {
 
 //--------- root-header crypto methods ----------
 
+// HashAlgorithm returns hash algorithm of the site
+func (h Header) HashAlgorithm() crypto.HashAlgorithm {
+	if alg := h.Get(headerHashAlg); alg != "" {
+		return crypto.HashAlgorithm(alg)
+	}
+	return crypto.DefaultHashAlgorithm
+}
+
+func (h *Header) SetHashAlgorithm(alg crypto.HashAlgorithm) {
+	h.Set(headerHashAlg, string(alg))
+}
+
 // Protocol returns VFS-Protocol
 func (h Header) Protocol() string {
 	return h.Get(headerProtocol)
@@ -329,13 +357,126 @@ func (h *Header) Sign(prv crypto.PrivateKey) {
 	h.AddBytes(headerSignature, prv.Sign(h.Hash()))
 }
 
+// SignBy signs the header by the signer
+func (h *Header) SignBy(s crypto.Signer) error {
+	h.SetPublicKey(s.PublicKey())
+	h.Delete(headerSignature)
+	sig, err := s.SignDigest(h.Hash())
+	if err != nil {
+		return err
+	}
+	h.AddBytes(headerSignature, sig)
+	return nil
+}
+
+// SigningDigest returns the digest of the header to be signed (hash of the header without Signature)
+func (h Header) SigningDigest() []byte {
+	return h.unsigned().Hash()
+}
+
+// SetSignature sets the signature of SigningDigest made out of the process (for example, on air-gapped machine).
+// Signatures of multi-signature Public-Key are joined with the existing ones.
+func (h *Header) SetSignature(sig []byte) error {
+	pub := h.PublicKey()
+	if pub.IsMulti() {
+		return h.mergeSignatures(sig)
+	}
+	if !pub.Verify(h.SigningDigest(), sig) {
+		return errInvalidSignature
+	}
+	h.Delete(headerSignature)
+	h.AddBytes(headerSignature, sig)
+	return nil
+}
+
+// AddSignature adds the signature of the signer to the multi-signature of the header.
+// The header must contain M-of-N Public-Key that includes the public key of the signer.
+func (h *Header) AddSignature(s crypto.Signer) error {
+	sig, err := crypto.MultiSign(s, h.PublicKey(), h.SigningDigest())
+	if err != nil {
+		return err
+	}
+	return h.mergeSignatures(sig)
+}
+
+// MergeSignatures joins the multi-signatures of the copies of the header signed by different signers
+func (h *Header) MergeSignatures(hh ...Header) error {
+	hash := h.unsigned().Hash()
+	var ss [][]byte
+	for _, h1 := range hh {
+		if !bytes.Equal(h1.unsigned().Hash(), hash) {
+			return errInvalidHeader
+		}
+		ss = append(ss, h1.GetBytes(headerSignature))
+	}
+	return h.mergeSignatures(ss...)
+}
+
+func (h *Header) mergeSignatures(ss ...[]byte) error {
+	if sig := h.GetBytes(headerSignature); len(sig) > 0 {
+		ss = append(ss, sig)
+	}
+	sig, err := crypto.MergeMultiSignatures(h.PublicKey(), h.unsigned().Hash(), ss...)
+	if err != nil {
+		return err
+	}
+	h.Delete(headerSignature)
+	h.AddBytes(headerSignature, sig)
+	return nil
+}
+
+// SignersCount returns the count of valid signatures of the header
+func (h Header) SignersCount() int {
+	if !h.HashAlgorithm().IsSupported() {
+		return 0
+	}
+	if !h.PublicKey().IsMulti() {
+		if h.Verify() {
+			return 1
+		}
+		return 0
+	}
+	return h.PublicKey().SignersCount(h.unsigned().Hash(), h.GetBytes(headerSignature))
+}
+
+// unsigned returns the header without Signature
+func (h Header) unsigned() Header {
+	if n := len(h); n > 0 && h[n-1].Name == headerSignature {
+		return h[:n-1]
+	}
+	return h
+}
+
 func (h Header) Verify() bool {
 	n := len(h)
 	return n >= 2 &&
 		h[n-1].Name == headerSignature && // last key is "Signature"
+		h.HashAlgorithm().IsSupported() &&
 		h.PublicKey().Verify(h[:n-1].Hash(), h[n-1].Value)
 }
 
+// VerifyHeaders verifies signatures of headers by one batch.
+// It returns indexes of headers with invalid signatures.
+func VerifyHeaders(hh ...Header) (invalid []int) {
+	v := crypto.NewBatchVerifier()
+	for _, h := range hh {
+		if n := len(h); n >= 2 && h[n-1].Name == headerSignature && h.HashAlgorithm().IsSupported() {
+			v.Add(h.PublicKey(), h[:n-1].Hash(), h[n-1].Value)
+		} else {
+			v.Add(nil, nil, nil)
+		}
+	}
+	if v.Verify() {
+		return nil
+	}
+	for i, ok := range v.VerifyEach() {
+		if !ok {
+			invalid = append(invalid, i)
+		}
+	}
+	return
+}
+
 //--------------------------------------------------------
 
 func ValidateHeader(h Header) error {
@@ -104,4 +104,26 @@ func TestHeader_Hash(t *testing.T) {
 
 func TestHeader_Verify(t *testing.T) {
 	assert(t, testHeaders[0].Verify())
+
+	// unsupported hash algorithm
+	h := testHeaders[0].Copy()
+	h.SetHashAlgorithm("bogus")
+	assert(t, !h.Verify())
+	assert(t, toJSON(VerifyHeaders(testHeaders[0], h)) == "[1]")
+	assert(t, VerifyHistory([]Header{h}) != nil)
+}
+
+func TestVerifyHeaders(t *testing.T) {
+	var hh []Header
+	for i := 0; i < 10; i++ {
+		h := testHeaders[0].Copy()
+		h.SetInt("Ver", int64(i+1))
+		h.Sign(testPrv.SubKey(string(rune('a' + i))))
+		hh = append(hh, h)
+	}
+	assert(t, VerifyHeaders(hh...) == nil)
+
+	hh[3].Set("Title", "Modified")
+	hh[7] = hh[7][:len(hh[7])-1] // without signature
+	assert(t, toJSON(VerifyHeaders(hh...)) == "[3,7]")
 }
@@ -0,0 +1,127 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db"
+	"time"
+)
+
+// dbKeyHistory is the storage key prefix of root-headers of the site versions (by hash of the header)
+const dbKeyHistory = ".history/"
+
+var errInvalidHistory = errors.New("invalid history of root-headers")
+
+// Prev returns hash of the root-header of the previous version
+func (h Header) Prev() []byte {
+	return h.GetBytes(headerPrev)
+}
+
+// VerifyHistory verifies the lineage of root-headers (in order of versions):
+// each header is signed and refers to the previous one by the field Prev.
+//
+// The authority of signers is checked by VFS.Commit. A root-header of the lineage
+// is committed by the signers of all the following versions, as they refer to it by hash.
+func VerifyHistory(hh []Header) error {
+	for i, h := range hh {
+		if h.Path() != "/" || h.Ver() <= 0 || !h.HashAlgorithm().IsSupported() {
+			return errInvalidHistory
+		}
+		if i > 0 && (h.Ver() <= hh[i-1].Ver() || !bytes.Equal(h.Prev(), hh[i-1].Hash())) {
+			return errInvalidHistory
+		}
+	}
+	if len(VerifyHeaders(hh...)) > 0 {
+		return errInvalidHistory
+	}
+	return nil
+}
+
+// verifyLineage verifies that the commit root-header b continues the history of the current root-header r.
+// It returns root-headers of the new versions (the intermediate versions of the commit history and b)
+// and path prefixes which their signers are authorized for (see authorizedPrefix).
+//
+// Every version must be signed by the key authorized for the site at the version. Certificates of delegates
+// must be valid at the local time or at the earliest time attested for the version or the following ones,
+// unless the version is followed by the version signed by the owner.
+func verifyLineage(site crypto.PublicKey, chain []Header, r, b Header, history []Header, attested func(Header) time.Time) (lineage []Header, prefixes []string, err error) {
+	for _, h := range history {
+		if h.Ver() > r.Ver() && h.Ver() < b.Ver() {
+			lineage = append(lineage, h)
+		}
+	}
+	lineage = append(lineage, b)
+	if err = VerifyHistory(lineage); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case r.Ver() == 0: // the history of the local site is empty
+	case b.Ver() == r.Ver(): // conflicting commit must have the same previous version
+		if !bytes.Equal(b.Prev(), r.Prev()) {
+			return nil, nil, errInvalidHistory
+		}
+	default:
+		if !bytes.Equal(lineage[0].Prev(), r.Hash()) {
+			return nil, nil, errInvalidHistory
+		}
+	}
+	prefixes = make([]string, len(lineage))
+	t := time.Now()   // the earliest time known to follow the version
+	endorsed := false // the version is followed by the version signed by the owner
+	for i := len(lineage) - 1; i >= 0; i-- {
+		h := lineage[i]
+		if at := attested(h); !at.IsZero() && at.Before(t) {
+			t = at
+		}
+		var tCert time.Time
+		if !endorsed {
+			tCert = t
+		}
+		owner, last := keyChainAt(site, chain, h.Ver())
+		if prefixes[i], err = authorizedPrefix(site, owner, last, h, tCert); err != nil {
+			if h.Ver() != b.Ver() {
+				err = errInvalidHistory
+			}
+			return nil, nil, err
+		}
+		endorsed = endorsed || !h.Has(headerDelegation)
+	}
+	return
+}
+
+func historyKey(hash []byte) string {
+	return dbKeyHistory + hex.EncodeToString(hash)
+}
+
+// History returns signed root-headers of the site versions newer than ver (in order of versions)
+func (f *fileSystem) History(ver int64) (hh []Header, err error) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	defer catch(&err)
+
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
+	if root := f.root(); root.Ver() > ver {
+		hh = append(f.history(ver), root.Copy())
+	}
+	return
+}
+
+// history returns stored root-headers of the versions newer than ver and older than the current version
+func (f *fileSystem) history(ver int64) (hh []Header) {
+	for h := f.root(); len(h.Prev()) > 0; {
+		var prev Header
+		try(db.GetJSON(f.db, historyKey(h.Prev()), &prev))
+		if prev == nil || prev.Ver() <= ver {
+			break
+		}
+		hh, h = append(hh, prev), prev
+	}
+	for i, j := 0, len(hh)-1; i < j; i, j = i+1, j-1 { // reverse
+		hh[i], hh[j] = hh[j], hh[i]
+	}
+	return
+}
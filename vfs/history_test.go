@@ -0,0 +1,127 @@
+package vfs
+
+import (
+	"bytes"
+	"github.com/denisskin/dweb/crypto"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFileSystem_History(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1", "commit2", "commit3")
+
+	hh, err := s.History(0)
+	assert(t, err == nil)
+	assert(t, len(hh) == 3)
+	assert(t, VerifyHistory(hh) == nil)
+	assert(t, len(hh[0].Prev()) == 0)
+	assert(t, bytes.Equal(hh[1].Prev(), hh[0].Hash()))
+	assert(t, bytes.Equal(hh[2].Hash(), tryVal(s.FileHeader("/")).Hash()))
+
+	hh, err = s.History(1)
+	assert(t, err == nil)
+	assert(t, len(hh) == 2 && hh[0].Ver() == 2)
+
+	hh, err = s.History(3)
+	assert(t, err == nil)
+	assert(t, len(hh) == 0)
+}
+
+func TestFileSystem_Commit_history(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1")
+	replica := newMemVFS()
+	try(replica.Commit(tryVal(s.GetCommit(0))))
+	applyCommit(s, "commit2", "commit3")
+
+	// commit without the intermediate versions
+	commit := tryVal(s.GetCommit(1))
+	assert(t, len(commit.History) == 1)
+	commit.History = nil
+	err := replica.Commit(commit)
+	assert(t, err != nil)
+
+	// commit with the intermediate versions
+	err = replica.Commit(tryVal(s.GetCommit(1)))
+	assert(t, err == nil)
+	hh := tryVal(replica.History(0))
+	assert(t, len(hh) == 3)
+	assert(t, VerifyHistory(hh) == nil)
+	assert(t, toJSON(hh) == toJSON(tryVal(s.History(0))))
+}
+
+func TestFileSystem_Commit_rewrittenHistory(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1")
+	fork := newMemVFS()
+	try(fork.Commit(tryVal(s.GetCommit(0))))
+	applyCommit(s, "commit2")
+	applyCommit(fork, "commit3", "commit3") // another version 2
+
+	// version 3 of the fork does not continue version 2 of the site
+	err := s.Commit(tryVal(fork.GetCommit(2)))
+	assert(t, err != nil)
+
+	err = s.Commit(tryVal(fork.GetCommit(0)))
+	assert(t, err != nil)
+}
+
+func TestFileSystem_Commit_invalidPrev(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1")
+
+	commit := makeTestCommit(s, "commit2")
+	commit.Headers[0].SetBytes(headerPrev, bytes.Repeat([]byte{1}, 32))
+	commit.Headers[0].Sign(testPrv)
+	err := s.Commit(commit)
+	assert(t, err != nil)
+
+	commit = makeTestCommit(s, "commit2")
+	commit.Headers[0].Delete(headerPrev)
+	commit.Headers[0].Sign(testPrv)
+	err = s.Commit(commit)
+	assert(t, err != nil)
+}
+
+func TestVerifyHistory(t *testing.T) {
+	s := applyCommit(newMemVFS(), "commit1", "commit2", "commit3")
+	hh := tryVal(s.History(0))
+
+	assert(t, VerifyHistory(hh) == nil)
+	assert(t, VerifyHistory(hh[1:]) == nil)
+	assert(t, VerifyHistory([]Header{hh[0], hh[2]}) != nil) // gap in the lineage
+	assert(t, VerifyHistory([]Header{hh[1], hh[0]}) != nil) // wrong order
+
+	h := hh[1].Copy()
+	h.Set("X", "x") // not signed
+	assert(t, VerifyHistory([]Header{hh[0], h}) != nil)
+}
+
+func TestFileSystem_Commit_unauthorizedHistory(t *testing.T) {
+	s, ts := newTestDelegatedVFS()
+	editor := testPrv.SubKey("editor")
+	cert := tryVal(NewDelegation(testPrv, testPub, editor.PublicKey(), "/blog/", ts.Add(time.Hour)))
+	src := fstest.MapFS{"blog/post2.txt": {Data: []byte("post2")}}
+
+	// delegate inserts the fabricated previous version into the lineage
+	makeCommit := func(signer crypto.PrivateKey) *Commit {
+		r := tryVal(s.FileHeader("/"))
+		fake := r.Copy()
+		fake.SetInt(headerVer, 2)
+		fake.SetBytes(headerPrev, r.Hash())
+		fake.Sign(signer)
+
+		commit := tryVal(MakeDelegatedCommit(s, editor, []Header{cert}, src, ts.Add(time.Second)))
+		commit.Headers[0].SetInt(headerVer, 3)
+		commit.Headers[0].SetBytes(headerPrev, fake.Hash())
+		commit.Headers[0].Sign(editor)
+		commit.History = []Header{fake}
+		return commit
+	}
+	err := s.Commit(makeCommit(testPrv.SubKey("fake")))
+	assert(t, err != nil)
+	assert(t, len(tryVal(s.History(0))) == 1)
+
+	// the previous version signed by the owner
+	err = s.Commit(makeCommit(testPrv))
+	assert(t, err == nil)
+	assert(t, len(tryVal(s.History(0))) == 3)
+}
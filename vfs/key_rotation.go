@@ -0,0 +1,86 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"io/fs"
+	"time"
+)
+
+// dbKeyKeyChain is the storage key of the site key chain.
+const dbKeyKeyChain = ".keys"
+
+var errInvalidKeyChain = errors.New("invalid key chain")
+
+// NextKeyHash returns the hash of the next key of the site committed by the root-header
+func (h Header) NextKeyHash() []byte {
+	return h.GetBytes(headerNextKey)
+}
+
+// SetNextKey commits the root-header to the next key of the site.
+// Only the next key can sign the commit that rotates ownership of the site.
+func (h *Header) SetNextKey(pub crypto.PublicKey) {
+	if pub == nil {
+		h.Delete(headerNextKey)
+		return
+	}
+	h.SetBytes(headerNextKey, h.HashAlgorithm().Hash(pub))
+}
+
+// isNextKey says that pub is the next key committed by the root-header
+func (h Header) isNextKey(pub crypto.PublicKey) bool {
+	hash := h.NextKeyHash()
+	return len(hash) > 0 && bytes.Equal(h.HashAlgorithm().Hash(pub), hash)
+}
+
+// verifyKeyChain verifies the key chain of the site and returns the current owner key and
+// the last root-header of the chain (that commits to the next key).
+//
+// The key chain is the sequence of root-headers that set Next-Key-Hash.
+// The first one is signed by the site key; every next one is signed by the key committed by the previous one.
+func verifyKeyChain(site crypto.PublicKey, chain []Header) (owner crypto.PublicKey, last Header, err error) {
+	if len(VerifyHeaders(chain...)) > 0 {
+		return nil, nil, errInvalidKeyChain
+	}
+	owner = site
+	for _, h := range chain {
+		pub := h.PublicKey()
+		if h.Path() != "/" || last != nil && h.Ver() <= last.Ver() {
+			return nil, nil, errInvalidKeyChain
+		}
+		if last != nil && len(last.NextKeyHash()) > 0 {
+			if !last.isNextKey(pub) { // rotation
+				return nil, nil, errInvalidKeyChain
+			}
+		} else if !pub.Equal(owner) || len(h.NextKeyHash()) == 0 { // the first commitment to next key
+			return nil, nil, errInvalidKeyChain
+		}
+		owner, last = pub, h
+	}
+	return
+}
+
+// keyChainAt returns the owner key and the last record of the verified key chain before the version ver
+func keyChainAt(site crypto.PublicKey, chain []Header, ver int64) (owner crypto.PublicKey, last Header) {
+	owner = site
+	for _, h := range chain {
+		if h.Ver() >= ver {
+			break
+		}
+		owner, last = h.PublicKey(), h
+	}
+	return
+}
+
+// MakeRotationCommit makes commit signed by the next key of the site that rotates ownership of the site to it.
+// The new root-header commits to the following key newNext (if it is not nil).
+//
+// If the site has no committed next key yet, next is the current owner key, and the commit sets the first commitment.
+func MakeRotationCommit(vfs VFS, next crypto.Signer, newNext crypto.PublicKey, src fs.FS, ts time.Time) (*Commit, error) {
+	return makeCommit(vfs, "/", src, ts, func(root *Header) {
+		root.SetDelegation(nil)
+		root.SetNextKey(newNext)
+		try(root.SignBy(next))
+	})
+}
@@ -0,0 +1,118 @@
+package vfs
+
+import (
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/vfs/test_data"
+	"testing"
+	"time"
+)
+
+func makeTestRotationCommit(vfs VFS, prv crypto.PrivateKey, next crypto.PublicKey, commitName string) *Commit {
+	tCommit := tryVal(vfs.FileHeader("/")).Updated().Add(time.Second)
+	return tryVal(MakeRotationCommit(vfs, prv, next, test_data.FS(commitName), tCommit))
+}
+
+func makeTestCommitBy(vfs VFS, prv crypto.PrivateKey, commitName string) *Commit {
+	tCommit := tryVal(vfs.FileHeader("/")).Updated().Add(time.Second)
+	return tryVal(MakeCommit(vfs, prv, test_data.FS(commitName), tCommit))
+}
+
+func TestFileSystem_Commit_keyRotation(t *testing.T) {
+	key1, key2, key3 := testPrv.SubKey("key1"), testPrv.SubKey("key2"), testPrv.SubKey("key3")
+	s := newMemVFS()
+
+	// owner commits to the next key
+	commit := makeTestRotationCommit(s, testPrv, key1.PublicKey(), "commit1")
+	err := s.Commit(commit)
+	assert(t, err == nil)
+	s1 := newMemVFS() // replica of version 1
+	err = s1.Commit(tryVal(s.GetCommit(0)))
+	assert(t, err == nil)
+
+	// the current key can not change the commitment
+	err = s.Commit(makeTestRotationCommit(s, testPrv, key2.PublicKey(), "commit2"))
+	assert(t, err != nil)
+
+	// not committed key can not rotate the key
+	err = s.Commit(makeTestRotationCommit(s, key2, key3.PublicKey(), "commit2"))
+	assert(t, err != nil)
+
+	// next key rotates ownership of the site
+	err = s.Commit(makeTestRotationCommit(s, key1, key2.PublicKey(), "commit2"))
+	assert(t, err == nil)
+
+	// old key is not an owner anymore
+	err = s.Commit(makeTestCommitBy(s, testPrv, "commit3"))
+	assert(t, err != nil)
+
+	// old key can not replace the rotation by the conflicting commit of the same version
+	s2 := newMemVFS() // stale replica of version 1
+	err = s2.Commit(tryVal(s1.GetCommit(0)))
+	assert(t, err == nil)
+	for ts := tryVal(s.FileHeader("/")).Updated(); ; ts = ts.Add(time.Second) {
+		commit = tryVal(MakeCommit(s2, testPrv, test_data.FS("commit2"), ts))
+		if VersionIsGreater(commit.Root(), tryVal(s.FileHeader("/"))) {
+			break
+		}
+	}
+	err = s2.Commit(commit)
+	assert(t, err == nil)
+	err = s.Commit(tryVal(s2.GetCommit(0)))
+	assert(t, err != nil)
+	assert(t, tryVal(s.FileHeader("/")).PublicKey().Equal(key1.PublicKey()))
+
+	// new owner commits; the commitment is kept
+	err = s.Commit(makeTestCommitBy(s, key1, "commit3"))
+	assert(t, err == nil)
+
+	// rotate again
+	err = s.Commit(makeTestRotationCommit(s, key2, nil, "commit3"))
+	assert(t, err == nil)
+	root := tryVal(s.FileHeader("/"))
+	assert(t, root.PublicKey().Equal(key2.PublicKey()))
+	assert(t, len(root.NextKeyHash()) == 0)
+
+	// replicas follow the key chain
+	for _, replica := range []VFS{s1, newMemVFS()} {
+		r := tryVal(replica.FileHeader("/"))
+		commit, err := s.GetCommit(r.Ver())
+		assert(t, err == nil)
+		assert(t, len(commit.KeyChain) > 0)
+		err = replica.Commit(commit)
+		assert(t, err == nil)
+		assert(t, toJSON(fsHeaders(s)) == toJSON(fsHeaders(replica)))
+	}
+
+	// range proof is verified by the site key
+	proof, err := s.FileRangeProof("/readme.txt", 0, 1)
+	assert(t, err == nil)
+	assert(t, len(proof.KeyChain) == 3)
+	assert(t, proof.Verify(testPub) == nil)
+	proof = tryVal(DecodeRangeProof(proof.Encode()))
+	assert(t, proof.Verify(testPub) == nil)
+	proof.KeyChain = proof.KeyChain[:2]
+	assert(t, proof.Verify(testPub) != nil)
+}
+
+func TestVerifyKeyChain(t *testing.T) {
+	key1 := testPrv.SubKey("key1")
+	s := newMemVFS()
+	try(s.Commit(makeTestRotationCommit(s, testPrv, key1.PublicKey(), "commit1")))
+	try(s.Commit(makeTestRotationCommit(s, key1, nil, "commit2")))
+	chain := s.(*fileSystem).keys
+	assert(t, len(chain) == 2)
+
+	owner, last, err := verifyKeyChain(testPub, chain)
+	assert(t, err == nil)
+	assert(t, owner.Equal(key1.PublicKey()))
+	assert(t, last.Ver() == 2)
+
+	_, _, err = verifyKeyChain(key1.PublicKey(), chain) // another site key
+	assert(t, err != nil)
+
+	_, _, err = verifyKeyChain(testPub, []Header{chain[1], chain[0]})
+	assert(t, err != nil)
+
+	_, _, err = verifyKeyChain(testPub, chain[1:])
+	assert(t, err != nil)
+}
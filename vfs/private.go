@@ -0,0 +1,481 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Private site is a site whose file contents and non-structural header fields are encrypted by the content key.
+//
+// The content key is wrapped for each reader key in the root-header field Readers.
+// File contents are encrypted by AES-256-CTR with the per-file Nonce, so headers Size and Merkle
+// are computed over ciphertext, and mirrors verify and replicate the site without the content key.
+// Non-structural header fields are sealed by AES-256-GCM into the field Encrypted.
+//
+// The nonce of a file is derived from the content key, the path and the file content,
+// so unchanged files of a new commit are encrypted to the same ciphertext.
+
+// private site fields
+const (
+	headerReaders   = "Readers"   // content key wrapped for the reader keys
+	headerNonce     = "Nonce"     // nonce of the encrypted file content
+	headerEncrypted = "Encrypted" // sealed non-structural fields of the header
+)
+
+const ContentKeySize = 32
+
+var (
+	ErrNotReader = errors.New("not a reader of the private site")
+
+	errInvalidContentKey = errors.New("invalid content key")
+	errInvalidEncrypted  = errors.New("invalid header Encrypted")
+)
+
+// structuralFields are kept in plaintext by private sites to verify and replicate them
+var structuralFields = map[string]bool{
+	headerProtocol:   true,
+	headerPublicKey:  true,
+	headerSignature:  true,
+	headerTreeVolume: true,
+	headerTreeMerkle: true,
+	headerHashAlg:    true,
+	headerDelegation: true,
+	headerNextKey:    true,
+	headerPrev:       true,
+	headerVer:        true,
+	headerPath:       true,
+	headerCreated:    true,
+	headerUpdated:    true,
+	headerDeleted:    true,
+	headerFileSize:   true,
+	headerFileMerkle: true,
+	headerPartSize:   true,
+	headerReaders:    true,
+	headerNonce:      true,
+	headerEncrypted:  true,
+}
+
+type wrappedKey struct {
+	Key     string // public key of the reader
+	Wrapped []byte // content key wrapped for the reader
+}
+
+// NewContentKey generates random content key of a private site
+func NewContentKey() []byte {
+	key := make([]byte, ContentKeySize)
+	tryVal(rand.Read(key))
+	return key
+}
+
+// IsPrivate says the root-header is of a private site
+func (h Header) IsPrivate() bool {
+	return h.Has(headerReaders)
+}
+
+// Readers returns public keys of the readers of the private site
+func (h Header) Readers() (readers []crypto.PublicKey) {
+	var ww []wrappedKey
+	json.Unmarshal(h.GetBytes(headerReaders), &ww)
+	for _, w := range ww {
+		readers = append(readers, crypto.DecodePublicKey(w.Key))
+	}
+	return
+}
+
+// SetReaders wraps the content key for the reader keys
+func (h *Header) SetReaders(key []byte, readers []crypto.PublicKey) error {
+	if len(key) != ContentKeySize {
+		return errInvalidContentKey
+	}
+	ww := make([]wrappedKey, 0, len(readers))
+	for _, pub := range readers {
+		wrapped, err := crypto.WrapKey(pub, key)
+		if err != nil {
+			return err
+		}
+		ww = append(ww, wrappedKey{pub.Encode(), wrapped})
+	}
+	h.SetBytes(headerReaders, tryVal(json.Marshal(ww)))
+	return nil
+}
+
+// ContentKey unwraps the content key of the private site by the private key of a reader
+func (h Header) ContentKey(prv crypto.PrivateKey) ([]byte, error) {
+	var ww []wrappedKey
+	if err := json.Unmarshal(h.GetBytes(headerReaders), &ww); err != nil {
+		return nil, ErrNotReader
+	}
+	pub := prv.PublicKey().Encode()
+	for _, w := range ww {
+		if w.Key == pub {
+			key, err := prv.UnwrapKey(w.Wrapped)
+			if err != nil || len(key) != ContentKeySize {
+				return nil, errInvalidContentKey
+			}
+			return key, nil
+		}
+	}
+	return nil, ErrNotReader
+}
+
+// MakePrivateCommit makes commit of files of src encrypted by the content key and signed by the signer.
+// The content key is wrapped in the root-header for the reader keys.
+//
+// If the content key is changed, all files of the site are re-encrypted by the new key.
+// To re-encrypt header fields sealed by the previous key, vfs must be the view returned by OpenPrivateVFS.
+//
+// To commit files keeping the content key and readers, use MakeCommit with the view returned by OpenPrivateVFS.
+func MakePrivateCommit(vfs VFS, signer crypto.Signer, key []byte, readers []crypto.PublicKey, src fs.FS, ts time.Time) (*Commit, error) {
+	c, err := newContentCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	v := &privateVFS{VFS: vfs, c: c}
+	if pv, ok := vfs.(*privateVFS); ok {
+		v.VFS = pv.VFS
+		if v.prev, err = pv.cipher(); err != nil {
+			return nil, err
+		}
+	}
+	return makeCommit(v, "/", src, ts, func(root *Header) {
+		root.SetDelegation(nil)
+		try(root.SetReaders(key, readers))
+		try(root.SignBy(signer))
+	})
+}
+
+// privateVFS is a view of the private site that decrypts file contents and headers
+type privateVFS struct {
+	VFS
+
+	prv  crypto.PrivateKey // private key of the reader (nil if the content key is given)
+	prev *contentCipher    // cipher of the previous content key (for commits that change the key)
+
+	mx      sync.Mutex
+	readers []byte         // value of the field Readers the cipher is unwrapped from
+	c       *contentCipher //
+}
+
+// OpenPrivateVFS returns view of the private site that transparently decrypts file contents (OpenAt)
+// and header fields (FileHeader, ReadDir) by the content key unwrapped by the private key of a reader.
+//
+// Headers returned by the view are not verifiable against the site Merkle tree; use the source VFS for proofs.
+// Commits made with the view (MakeCommit, MakeDelegatedCommit, ...) are encrypted by the content key.
+func OpenPrivateVFS(vfs VFS, prv crypto.PrivateKey) (VFS, error) {
+	v := &privateVFS{VFS: vfs, prv: prv}
+	if _, err := v.cipher(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// cipher returns the cipher of the current content key of the site
+func (v *privateVFS) cipher() (*contentCipher, error) {
+	if v.prv == nil {
+		return v.c, nil
+	}
+	root, err := v.VFS.FileHeader("/")
+	if err != nil {
+		return nil, err
+	}
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	if readers := root.GetBytes(headerReaders); v.c == nil || !bytes.Equal(readers, v.readers) {
+		key, err := root.ContentKey(v.prv)
+		if err != nil {
+			return nil, err
+		}
+		if v.c, err = newContentCipher(key); err != nil {
+			return nil, err
+		}
+		v.readers = readers
+	}
+	return v.c, nil
+}
+
+func (v *privateVFS) FileHeader(path string) (Header, error) {
+	c, err := v.cipher()
+	if err != nil {
+		return nil, err
+	}
+	h, err := v.VFS.FileHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.decryptHeader(h)
+}
+
+func (v *privateVFS) ReadDir(path string) ([]Header, error) {
+	c, err := v.cipher()
+	if err != nil {
+		return nil, err
+	}
+	hh, err := v.VFS.ReadDir(path)
+	for i := range hh {
+		if err == nil {
+			hh[i], err = c.decryptHeader(hh[i])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hh, nil
+}
+
+func (v *privateVFS) OpenAt(path string, offset int64) (io.ReadCloser, error) {
+	c, err := v.cipher()
+	if err != nil {
+		return nil, err
+	}
+	h, err := v.VFS.FileHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	nonce := h.GetBytes(headerNonce)
+	if len(nonce) != aes.BlockSize {
+		return nil, errInvalidHeader
+	}
+	r, err := v.VFS.OpenAt(path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r, c.stream(nonce, offset)}, nil
+}
+
+// sealRoot encrypts plaintext non-structural fields of the new root-header
+func (v *privateVFS) sealRoot(root Header) (_ Header, err error) {
+	c, err := v.cipher()
+	if err != nil {
+		return
+	}
+	if v.prev != nil && v.prev != c { // content key is changed
+		if root, err = v.prev.decryptHeader(root); err != nil {
+			return
+		}
+	}
+	return c.encryptHeader(root)
+}
+
+func (v *privateVFS) commitSource(src fs.FS) (*commitSource, error) {
+	enc, err := v.encryptFS(src)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := newCommitSource(v.VFS, enc)
+	if err != nil {
+		return nil, err
+	}
+	cs.nonce, cs.seal = enc.nonce, v.sealRoot
+	return cs, nil
+}
+
+// encryptFS returns FS with file contents of src encrypted by the content key
+func (v *privateVFS) encryptFS(src fs.FS) (*encryptedFS, error) {
+	c, err := v.cipher()
+	if err != nil {
+		return nil, err
+	}
+	root, err := v.VFS.FileHeader("/")
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedFS{FS: src, c: c, alg: root.HashAlgorithm(), nonces: map[string][]byte{}}, nil
+}
+
+type decryptReader struct {
+	io.ReadCloser
+	s cipher.Stream
+}
+
+func (r *decryptReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.s.XORKeyStream(p[:n], p[:n])
+	return
+}
+
+//-------------- content cipher ----------------
+
+type contentCipher struct {
+	file     cipher.Block // key of file contents
+	header   cipher.AEAD  // key of header fields
+	nonceKey []byte       // key of file nonces
+}
+
+func newContentCipher(key []byte) (*contentCipher, error) {
+	if len(key) != ContentKeySize {
+		return nil, errInvalidContentKey
+	}
+	file, _ := aes.NewCipher(crypto.SHA256.Hash([]byte("dweb-file-key:"), key))
+	block, _ := aes.NewCipher(crypto.SHA256.Hash([]byte("dweb-header-key:"), key))
+	header, _ := cipher.NewGCM(block)
+	return &contentCipher{
+		file:     file,
+		header:   header,
+		nonceKey: crypto.SHA256.Hash([]byte("dweb-nonce-key:"), key),
+	}, nil
+}
+
+// fileNonce returns nonce of the file with the path and the content hash
+func (c *contentCipher) fileNonce(path string, contentHash []byte) []byte {
+	m := hmac.New(sha256.New, c.nonceKey)
+	m.Write([]byte(path))
+	m.Write([]byte{0})
+	m.Write(contentHash)
+	return m.Sum(nil)[:aes.BlockSize]
+}
+
+// stream returns key stream of the file content starting from the offset
+func (c *contentCipher) stream(nonce []byte, offset int64) cipher.Stream {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, nonce)
+	for i, n := aes.BlockSize-1, uint64(offset/aes.BlockSize); i >= 0 && n > 0; i-- { // iv += offset/BlockSize
+		n += uint64(iv[i])
+		iv[i], n = byte(n), n>>8
+	}
+	s := cipher.NewCTR(c.file, iv)
+	if skip := offset % aes.BlockSize; skip > 0 {
+		buf := make([]byte, skip)
+		s.XORKeyStream(buf, buf)
+	}
+	return s
+}
+
+// encryptHeader seals plaintext non-structural fields of the header into the field Encrypted
+func (c *contentCipher) encryptHeader(h Header) (Header, error) {
+	if len(h.withoutStructuralFields()) == 0 { // nothing to encrypt
+		return h, nil
+	}
+	h, err := c.decryptHeader(h) // merge with the previously encrypted fields
+	if err != nil {
+		return nil, err
+	}
+	var res Header
+	for _, kv := range h {
+		if structuralFields[kv.Name] {
+			res = append(res, kv)
+		}
+	}
+	nonce := make([]byte, c.header.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plain := []byte(h.withoutStructuralFields().String())
+	res.AddBytes(headerEncrypted, c.header.Seal(nonce, nonce, plain, []byte(h.Path())))
+	return res, nil
+}
+
+// decryptHeader replaces the field Encrypted of the header by the decrypted fields.
+// Plaintext fields of the header take precedence over the decrypted ones.
+func (c *contentCipher) decryptHeader(h Header) (Header, error) {
+	data := h.GetBytes(headerEncrypted)
+	if data == nil {
+		return h, nil
+	}
+	n := c.header.NonceSize()
+	if len(data) < n {
+		return nil, errInvalidEncrypted
+	}
+	plain, err := c.header.Open(nil, data[:n], data[n:], []byte(h.Path()))
+	if err != nil {
+		return nil, errInvalidEncrypted
+	}
+	var fields Header
+	if err = json.Unmarshal(plain, &fields); err != nil {
+		return nil, errInvalidEncrypted
+	}
+	var res Header
+	for _, kv := range h {
+		if kv.Name != headerEncrypted && kv.Name != headerSignature {
+			res = append(res, kv)
+		}
+	}
+	for _, kv := range fields {
+		if !structuralFields[kv.Name] && !res.Has(kv.Name) {
+			res = append(res, kv)
+		}
+	}
+	if h.Has(headerSignature) { // keep the signature the last field
+		res.AddBytes(headerSignature, h.GetBytes(headerSignature))
+	}
+	return res, nil
+}
+
+func (h Header) withoutStructuralFields() (res Header) {
+	for _, kv := range h {
+		if !structuralFields[kv.Name] {
+			res = append(res, kv)
+		}
+	}
+	return
+}
+
+//-------------- encrypted FS ----------------
+
+// encryptedFS is FS with file contents encrypted by the content key
+type encryptedFS struct {
+	fs.FS
+	c      *contentCipher
+	alg    crypto.HashAlgorithm
+	nonces map[string][]byte
+}
+
+// nonce returns nonce of the file (name is the path in src without prefix '/')
+func (e *encryptedFS) nonce(name string) ([]byte, error) {
+	if nonce := e.nonces[name]; nonce != nil {
+		return nonce, nil
+	}
+	f, err := e.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := e.alg.New()
+	if _, err = io.Copy(w, f); err != nil {
+		return nil, err
+	}
+	nonce := e.c.fileNonce("/"+name, w.Sum(nil))
+	e.nonces[name] = nonce
+	return nonce, nil
+}
+
+func (e *encryptedFS) Open(name string) (fs.File, error) {
+	f, err := e.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err == nil && st.IsDir() {
+		return f, nil
+	}
+	var nonce []byte
+	if err == nil {
+		nonce, err = e.nonce(name)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &encryptedFile{f, e.c.stream(nonce, 0)}, nil
+}
+
+type encryptedFile struct {
+	fs.File
+	s cipher.Stream
+}
+
+func (f *encryptedFile) Read(p []byte) (n int, err error) {
+	n, err = f.File.Read(p)
+	f.s.XORKeyStream(p[:n], p[:n])
+	return
+}
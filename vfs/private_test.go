@@ -0,0 +1,144 @@
+package vfs
+
+import (
+	"bytes"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/vfs/test_data"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func makeTestPrivateCommit(vfs VFS, key []byte, readers []crypto.PublicKey, commitName string) *Commit {
+	tCommit := tryVal(vfs.FileHeader("/")).Updated().Add(time.Second)
+	return tryVal(MakePrivateCommit(vfs, testPrv, key, readers, test_data.FS(commitName), tCommit))
+}
+
+func readTestFile(vfs VFS, path string, offset int64) []byte {
+	r := tryVal(vfs.OpenAt(path, offset))
+	defer r.Close()
+	return tryVal(io.ReadAll(r))
+}
+
+func TestMakePrivateCommit(t *testing.T) {
+	reader := testPrv.SubKey("reader")
+	s := newMemVFS()
+
+	commit := makeTestPrivateCommit(s, NewContentKey(), []crypto.PublicKey{testPub, reader.PublicKey()}, "commit1")
+	err := s.Commit(commit)
+	assert(t, err == nil)
+
+	root := tryVal(s.FileHeader("/"))
+	assert(t, root.IsPrivate())
+	assert(t, len(root.Readers()) == 2)
+	assert(t, root.Readers()[1].Equal(reader.PublicKey()))
+
+	// file contents are encrypted
+	plain := tryVal(fs.ReadFile(test_data.FS("commit1"), "readme.txt"))
+	data := readTestFile(s, "/readme.txt", 0)
+	assert(t, len(data) == len(plain))
+	assert(t, !bytes.Equal(data, plain))
+	assert(t, len(tryVal(s.FileHeader("/readme.txt")).GetBytes(headerNonce)) == 16)
+
+	// mirror verifies and replicates the site without the content key
+	mirror := newMemVFS()
+	err = mirror.Commit(tryVal(s.GetCommit(0)))
+	assert(t, err == nil)
+	proof := tryVal(mirror.FileRangeProof("/readme.txt", 0, 3))
+	assert(t, proof.Verify(testPub) == nil)
+
+	// reader decrypts file contents
+	view, err := OpenPrivateVFS(mirror, reader)
+	assert(t, err == nil)
+	assert(t, bytes.Equal(readTestFile(view, "/readme.txt", 0), plain))
+	assert(t, bytes.Equal(readTestFile(view, "/readme.txt", 5), plain[5:]))
+	assert(t, len(tryVal(view.ReadDir("/A/"))) == 2)
+
+	// not a reader
+	_, err = OpenPrivateVFS(mirror, testPrv.SubKey("other"))
+	assert(t, err == ErrNotReader)
+
+	// commit of the private site without the content key
+	_, err = MakeCommit(s, testPrv, test_data.FS("commit2"), time.Now())
+	assert(t, err != nil)
+}
+
+func TestPrivateVFS_MakeCommit(t *testing.T) {
+	s := newMemVFS()
+	try(s.Commit(makeTestPrivateCommit(s, NewContentKey(), []crypto.PublicKey{testPub}, "commit1")))
+	view := tryVal(OpenPrivateVFS(s, testPrv))
+
+	// commit by the view keeps the content key and readers
+	commit := makeTestCommit(view, "commit2")
+	err := s.Commit(commit)
+	assert(t, err == nil)
+
+	plain := tryVal(fs.ReadFile(test_data.FS("commit2"), "A/1.txt"))
+	assert(t, bytes.Equal(readTestFile(view, "/A/1.txt", 0), plain))
+
+	// unchanged files are encrypted to the same ciphertext
+	commit = makeTestCommit(view, "commit2")
+	assert(t, len(commit.Headers) == 1)
+}
+
+func TestPrivateVFS_OpenAt(t *testing.T) {
+	s := newMemVFS()
+	data := bytes.Repeat([]byte("0123456789"), 500) // 5000 bytes; 5 parts
+	src := fstest.MapFS{"big.txt": {Data: data}}
+	commit := tryVal(MakePrivateCommit(s, testPrv, NewContentKey(), []crypto.PublicKey{testPub}, src, time.Now()))
+	try(s.Commit(commit))
+	view := tryVal(OpenPrivateVFS(s, testPrv))
+
+	for _, offset := range []int64{0, 1, 15, 16, 17, 1024, 4095, 4999} {
+		assert(t, bytes.Equal(readTestFile(view, "/big.txt", offset), data[offset:]))
+	}
+}
+
+func TestMakePrivateCommit_changeKey(t *testing.T) {
+	reader := testPrv.SubKey("reader")
+	s := newMemVFS()
+	try(s.Commit(makeTestPrivateCommit(s, NewContentKey(), []crypto.PublicKey{testPub, reader.PublicKey()}, "commit1")))
+	readerView := tryVal(OpenPrivateVFS(s, reader))
+	ownerView := tryVal(OpenPrivateVFS(s, testPrv))
+
+	// owner removes the reader and changes the content key
+	commit := makeTestPrivateCommit(ownerView, NewContentKey(), []crypto.PublicKey{testPub}, "commit1")
+	assert(t, len(commit.Headers) > 1) // files are re-encrypted
+	err := s.Commit(commit)
+	assert(t, err == nil)
+
+	plain := tryVal(fs.ReadFile(test_data.FS("commit1"), "readme.txt"))
+	assert(t, bytes.Equal(readTestFile(ownerView, "/readme.txt", 0), plain))
+
+	_, err = readerView.OpenAt("/readme.txt", 0)
+	assert(t, err == ErrNotReader)
+}
+
+func TestContentCipher_encryptHeader(t *testing.T) {
+	c := tryVal(newContentCipher(NewContentKey()))
+	h := decodeHeader(`{"Path":"/A/","Ver":"1","Title":"Secret","Tags":"a,b"}`)
+
+	e := tryVal(c.encryptHeader(h))
+	assert(t, !e.Has("Title"))
+	assert(t, e.Has(headerEncrypted))
+	assert(t, e.Path() == "/A/" && e.Ver() == 1)
+
+	d := tryVal(c.decryptHeader(e))
+	assert(t, d.String() == h.String())
+
+	// new plaintext fields are merged with the encrypted ones
+	e.Set("Title", "New")
+	d = tryVal(c.decryptHeader(tryVal(c.encryptHeader(e))))
+	assert(t, d.Get("Title") == "New" && d.Get("Tags") == "a,b")
+
+	// the encrypted fields are bound to the path
+	e.Set(headerPath, "/B/")
+	_, err := c.decryptHeader(e)
+	assert(t, err == errInvalidEncrypted)
+
+	// another key
+	_, err = tryVal(newContentCipher(NewContentKey())).decryptHeader(tryVal(c.encryptHeader(h)))
+	assert(t, err == errInvalidEncrypted)
+}
@@ -0,0 +1,232 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"io"
+	"strings"
+)
+
+// RangeProof is a self-contained proof that file bytes [Offset, Offset+Size) belong
+// to the version of the file tree signed by the root header.
+type RangeProof struct {
+	Root          Header   // signed root header
+	File          Header   // file header
+	TreeWitness   []byte   // merkle-witness of the file header hash to the root Merkle-Root
+	Offset        int64    // range offset
+	Size          int64    // range size
+	Parts         [][]byte // contents of file-parts that cover the range
+	PartWitnesses [][]byte // merkle-witnesses of file-parts to the file Merkle
+	KeyChain      []Header // key chain of the site (if the site key was rotated)
+}
+
+var errInvalidRangeProof = errors.New("invalid range proof")
+
+func (f *fileSystem) FileRangeProof(path string, offset, size int64) (p *RangeProof, err error) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	defer catch(&err)
+
+	if err = f.verifyNotRevoked(); err != nil {
+		return
+	}
+	nd := f.nodes[path]
+	if nd == nil || nd.isDir() || nd.deleted() {
+		return nil, ErrNotFound
+	}
+	h := nd.Header
+	if offset < 0 || size <= 0 || offset+size > h.FileSize() {
+		return nil, ErrNotFound
+	}
+	partSize := f.filePartSize(h)
+	first, last := int(offset/partSize), int((offset+size-1)/partSize)
+
+	hashes := tryVal(f.fileParts(path))
+	fl := tryVal(f.blobs.Open(BlobID(f.root(), h)))
+	defer fl.Close()
+	tryVal(fl.Seek(int64(first)*partSize, io.SeekStart))
+
+	alg := f.hashAlg()
+	p = &RangeProof{
+		Root:        f.root().Copy(),
+		File:        h.Copy(),
+		TreeWitness: f.nodes["/"].childrenMerkleWitness(path)[alg.Size():],
+		Offset:      offset,
+		Size:        size,
+	}
+	for _, h := range f.keys {
+		p.KeyChain = append(p.KeyChain, h.Copy())
+	}
+	for i := first; i <= last; i++ {
+		part := make([]byte, partSize)
+		n, err := io.ReadFull(fl, part)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		try(err)
+		p.Parts = append(p.Parts, part[:n])
+		p.PartWitnesses = append(p.PartWitnesses, alg.MakeMerkleWitness(hashes, i)[alg.Size():])
+	}
+	return
+}
+
+// Ver returns version of the file tree
+func (p *RangeProof) Ver() int64 {
+	return p.Root.Ver()
+}
+
+// Path returns file path
+func (p *RangeProof) Path() string {
+	return p.File.Path()
+}
+
+// Data returns content of the proved range
+func (p *RangeProof) Data() []byte {
+	partSize := partSizeOf(p.Root, p.File)
+	if partSize <= 0 || p.Offset < 0 || p.Size < 0 {
+		return nil
+	}
+	offset := p.Offset % partSize
+	data := bytes.Join(p.Parts, nil)
+	if p.Size > int64(len(data))-offset { // offset+Size can overflow
+		return nil
+	}
+	return data[offset : offset+p.Size]
+}
+
+// Verify checks the proof against public key of the site.
+// A root-header signed by a delegated key proves only files under the delegated path prefix;
+// its certificates are checked against the Updated time of the root-header only
+// (the time when the header was received is unknown to the verifier).
+func (p *RangeProof) Verify(pub crypto.PublicKey) (err error) {
+	defer catch(&err)
+
+	r, h := p.Root, p.File
+
+	//--- verify root-header
+	try(ValidateHeader(r))
+	require(r.Path() == "/", "invalid root-header Path")
+	owner, _ := tryVal2(verifyKeyChain(pub, p.KeyChain))
+	prefix := "/"
+	if !r.PublicKey().Equal(owner) {
+		prefix = tryVal(verifyDelegation(pub, owner, r, r.Updated()))
+	}
+	require(r.Verify(), "invalid root-header Signature")
+
+	//--- verify file-header
+	try(ValidateHeader(h))
+	require(strings.HasPrefix(h.Path(), prefix), "file-header Path is out of delegated prefix")
+	require(h.IsFile() && !h.Deleted(), "invalid file-header")
+	alg := r.HashAlgorithm()
+	require(alg.IsSupported(), "unsupported root-header Hash-Algorithm")
+	require(alg.VerifyMerkleWitness(h.HashBy(alg, r.Protocol()), r.TreeMerkleRoot(), p.TreeWitness), "invalid tree witness")
+
+	//--- verify file-parts
+	partSize := partSizeOf(r, h)
+	require(partSize > 0, "invalid Part-Size")
+	require(p.Offset >= 0 && p.Size > 0 && p.Offset <= h.FileSize() && p.Size <= h.FileSize()-p.Offset, "invalid range")
+	first, last := int(p.Offset/partSize), int((p.Offset+p.Size-1)/partSize)
+	require(len(p.Parts) == last-first+1 && len(p.PartWitnesses) == len(p.Parts), "invalid count of parts")
+	for i, part := range p.Parts {
+		require(VerifyFilePart(r, h, first+i, part, p.PartWitnesses[i]), "invalid part witness")
+	}
+	return
+}
+
+//--------- canonical encoding ----------
+
+// Encode returns canonical binary encoding of the proof
+func (p *RangeProof) Encode() []byte {
+	var buf []byte
+	buf = appendHeader(buf, p.Root)
+	buf = appendHeader(buf, p.File)
+	buf = appendBytes(buf, p.TreeWitness)
+	buf = binary.AppendUvarint(buf, uint64(p.Offset))
+	buf = binary.AppendUvarint(buf, uint64(p.Size))
+	buf = binary.AppendUvarint(buf, uint64(len(p.Parts)))
+	for i, part := range p.Parts {
+		buf = appendBytes(buf, part)
+		buf = appendBytes(buf, p.PartWitnesses[i])
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(p.KeyChain)))
+	for _, h := range p.KeyChain {
+		buf = appendHeader(buf, h)
+	}
+	return buf
+}
+
+// DecodeRangeProof decodes proof from its canonical binary encoding
+func DecodeRangeProof(data []byte) (p *RangeProof, err error) {
+	defer catch(&err)
+
+	r := &binReader{data}
+	p = &RangeProof{
+		Root:        r.readHeader(),
+		File:        r.readHeader(),
+		TreeWitness: r.readBytes(),
+		Offset:      int64(r.readUint()),
+		Size:        int64(r.readUint()),
+	}
+	n := r.readUint()
+	require(n <= uint64(len(r.buf)), errInvalidRangeProof.Error())
+	for i := uint64(0); i < n; i++ {
+		p.Parts = append(p.Parts, r.readBytes())
+		p.PartWitnesses = append(p.PartWitnesses, r.readBytes())
+	}
+	n = r.readUint()
+	require(n <= uint64(len(r.buf)), errInvalidRangeProof.Error())
+	for i := uint64(0); i < n; i++ {
+		p.KeyChain = append(p.KeyChain, r.readHeader())
+	}
+	require(len(r.buf) == 0 && p.Offset >= 0 && p.Size >= 0, errInvalidRangeProof.Error())
+	return
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendHeader(buf []byte, h Header) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(h)))
+	for _, kv := range h {
+		buf = appendBytes(buf, []byte(kv.Name))
+		buf = appendBytes(buf, kv.Value)
+	}
+	return buf
+}
+
+type binReader struct {
+	buf []byte
+}
+
+func (r *binReader) readUint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		try(errInvalidRangeProof)
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *binReader) readBytes() []byte {
+	n := r.readUint()
+	if n > uint64(len(r.buf)) {
+		try(errInvalidRangeProof)
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *binReader) readHeader() (h Header) {
+	n := r.readUint()
+	require(n <= uint64(len(r.buf)), errInvalidRangeProof.Error())
+	h = make(Header, 0, n)
+	for i := uint64(0); i < n; i++ {
+		h = append(h, HeaderField{string(r.readBytes()), r.readBytes()})
+	}
+	return
+}
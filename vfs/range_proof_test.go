@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"bytes"
+	"github.com/denisskin/dweb/crypto"
+	"math"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFileSystem_FileRangeProof(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000) // 10000 bytes
+	s := newMemVFS()
+	try(s.Commit(tryVal(MakeCommit(s, testPrv, fstest.MapFS{"big.txt": {Data: data}}, time.Now()))))
+
+	for _, r := range [][2]int64{{0, 1}, {0, 10000}, {1000, 100}, {1020, 10}, {1500, 3000}, {9999, 1}} {
+		offset, size := r[0], r[1]
+
+		proof, err := s.FileRangeProof("/big.txt", offset, size)
+		assert(t, err == nil)
+
+		// encode-decode
+		proof, err = DecodeRangeProof(proof.Encode())
+		assert(t, err == nil)
+
+		// verify
+		err = proof.Verify(testPub)
+		assert(t, err == nil)
+		assert(t, proof.Path() == "/big.txt")
+		assert(t, bytes.Equal(proof.Data(), data[offset:offset+size]))
+	}
+}
+
+func TestRangeProof_Verify_fail(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	s := newMemVFS()
+	try(s.Commit(tryVal(MakeCommit(s, testPrv, fstest.MapFS{"big.txt": {Data: data}}, time.Now()))))
+
+	newProof := func() *RangeProof {
+		return tryVal(DecodeRangeProof(tryVal(s.FileRangeProof("/big.txt", 1500, 1000)).Encode()))
+	}
+
+	// invalid range
+	_, err := s.FileRangeProof("/big.txt", 9000, 1001)
+	assert(t, err == ErrNotFound)
+
+	// invalid public key
+	err = newProof().Verify(testPrv.SubKey("x").PublicKey())
+	assert(t, err != nil)
+
+	// corrupted content
+	p := newProof()
+	p.Parts[1][0]++
+	assert(t, p.Verify(testPub) != nil)
+
+	// corrupted range
+	p = newProof()
+	p.Offset += 1024
+	assert(t, p.Verify(testPub) != nil)
+
+	// overflowed range
+	p = newProof()
+	p.Size = math.MaxInt64
+	assert(t, p.Verify(testPub) != nil)
+	assert(t, p.Data() == nil)
+	p.Offset, p.Size = -1, 1
+	assert(t, p.Verify(testPub) != nil)
+	assert(t, p.Data() == nil)
+
+	// corrupted file-header
+	p = newProof()
+	p.File.SetInt("Size", 10001)
+	assert(t, p.Verify(testPub) != nil)
+
+	// corrupted root-header
+	p = newProof()
+	p.Root.SetInt("Ver", 2)
+	assert(t, p.Verify(testPub) != nil)
+
+	// truncated encoding
+	enc := newProof().Encode()
+	_, err = DecodeRangeProof(enc[:len(enc)-1])
+	assert(t, err != nil)
+}
+
+func TestRangeProof_Verify_forgedMerkle(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	s := newMemVFS()
+	try(s.Commit(tryVal(MakeCommit(s, testPrv, fstest.MapFS{"big.txt": {Data: data}}, time.Now()))))
+
+	// replace the content of the same size and consistent file Merkle
+	forged := bytes.Repeat([]byte("x"), len(data))
+	w := crypto.NewMerkleHash(1024)
+	w.Write(forged)
+	p := tryVal(s.FileRangeProof("/big.txt", 0, 1024))
+	p.File.SetBytes("Merkle", w.Root())
+	p.Parts[0] = forged[:1024]
+	p.PartWitnesses[0] = crypto.MakeMerkleWitness(w.Leaves(), 0)[crypto.HashSize:]
+
+	assert(t, p.Verify(testPub) != nil)
+}
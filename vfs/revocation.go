@@ -0,0 +1,188 @@
+package vfs
+
+import (
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db"
+	"sync"
+	"time"
+)
+
+// revocation record fields
+const (
+	headerRevokedKey = "Revoked-Key" // revoked public key
+	headerRevoked    = "Revoked"     // time since that the key is compromised
+)
+
+// dbKeyRevocations is the storage key of revocation records
+const dbKeyRevocations = ".revocations"
+
+// dbKeyReceived is the storage key of the local time when the current version was received
+const dbKeyReceived = ".received"
+
+var errInvalidRevocation = errors.New("invalid revocation record")
+
+// NewRevocation makes record that marks the key pub as compromised since the time ts.
+// The record is signed by the key itself or by the owner key of the site.
+//
+// The record is a header: {Revoked-Key, Revoked, Public-Key, Signature}.
+func NewRevocation(signer crypto.Signer, pub crypto.PublicKey, ts time.Time) (h Header, err error) {
+	h.Add(headerRevokedKey, pub.Encode())
+	h.AddTime(headerRevoked, ts)
+	if err = h.SignBy(signer); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// RevokedKey returns the key revoked by the record
+func (h Header) RevokedKey() crypto.PublicKey {
+	return crypto.DecodePublicKey(h.Get(headerRevokedKey))
+}
+
+// RevokedAt returns the time since that the key is compromised
+func (h Header) RevokedAt() time.Time {
+	return h.GetTime(headerRevoked)
+}
+
+// VerifyRevocation verifies format and signature of the revocation record
+func VerifyRevocation(h Header) error {
+	if h.RevokedKey() == nil || h.RevokedAt().IsZero() || !h.Verify() {
+		return errInvalidRevocation
+	}
+	return nil
+}
+
+// RevocationStore is a local store of key revocation records
+type RevocationStore interface {
+
+	// AddRevocation verifies and saves the revocation record
+	AddRevocation(h Header) error
+
+	// Revocations returns revocation records of the key
+	Revocations(pub crypto.PublicKey) ([]Header, error)
+}
+
+type revocationStore struct {
+	mx      sync.RWMutex
+	db      db.Storage
+	records []Header
+}
+
+// OpenRevocationStore opens revocation store persisted in the storage
+func OpenRevocationStore(storage db.Storage) (_ RevocationStore, err error) {
+	defer catch(&err)
+	s := &revocationStore{db: storage}
+	try(db.GetJSON(storage, dbKeyRevocations, &s.records))
+	require(len(VerifyHeaders(s.records...)) == 0, errInvalidRevocation.Error())
+	for _, h := range s.records {
+		require(h.RevokedKey() != nil && !h.RevokedAt().IsZero(), errInvalidRevocation.Error())
+	}
+	return s, nil
+}
+
+func (s *revocationStore) AddRevocation(h Header) (err error) {
+	if err = VerifyRevocation(h); err != nil {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	records := make([]Header, 0, len(s.records)+1)
+	for _, r := range s.records {
+		if r.RevokedKey().Equal(h.RevokedKey()) && r.PublicKey().Equal(h.PublicKey()) { // record of the same signer
+			if !h.RevokedAt().Before(r.RevokedAt()) {
+				return
+			}
+			continue // replace by the earlier record
+		}
+		records = append(records, r)
+	}
+	records = append(records, h.Copy())
+	if err = s.db.Execute(func(tx db.Transaction) error {
+		return db.PutJSON(tx, dbKeyRevocations, records)
+	}); err == nil {
+		s.records = records
+	}
+	return
+}
+
+func (s *revocationStore) Revocations(pub crypto.PublicKey) ([]Header, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	var rr []Header
+	for _, r := range s.records {
+		if r.RevokedKey().Equal(pub) {
+			rr = append(rr, r.Copy())
+		}
+	}
+	return rr, nil
+}
+
+// keyRevokedAt returns the time since that the key is revoked for the site with the owner key (or zero time).
+// Only records signed by the key itself or by the owner are taken into account.
+func keyRevokedAt(rs RevocationStore, owner, pub crypto.PublicKey) (t time.Time) {
+	if rs == nil || pub == nil {
+		return
+	}
+	rr, err := rs.Revocations(pub)
+	try(err)
+	for _, r := range rr {
+		if signer := r.PublicKey(); (signer.Equal(pub) || signer.Equal(owner)) && (t.IsZero() || r.RevokedAt().Before(t)) {
+			t = r.RevokedAt()
+		}
+	}
+	return
+}
+
+// signersRevokedAt returns the earliest revocation time of the keys that signed the root-header
+// (signer and delegation chain keys) or zero time.
+func signersRevokedAt(rs RevocationStore, owner crypto.PublicKey, root Header) (t time.Time) {
+	if rs == nil {
+		return
+	}
+	keys := []crypto.PublicKey{root.PublicKey()}
+	chain, _ := root.Delegation()
+	for _, c := range chain {
+		keys = append(keys, c.PublicKey(), crypto.DecodePublicKey(c.Get(headerDelegate)))
+	}
+	for _, pub := range keys {
+		if tr := keyRevokedAt(rs, owner, pub); !tr.IsZero() && (t.IsZero() || tr.Before(t)) {
+			t = tr
+		}
+	}
+	return
+}
+
+// verifyNotRevoked checks that keys that signed the root-header were not revoked
+// before the root-header was updated and before the time it was received
+// (the earliest local or notary-attested time; zero time is not checked).
+// Updated is chosen by the signer, so the root-header signed by the leaked key can be backdated.
+func verifyNotRevoked(rs RevocationStore, owner crypto.PublicKey, root Header, received time.Time) (err error) {
+	defer catch(&err)
+	if t := signersRevokedAt(rs, owner, root); !t.IsZero() && (!root.Updated().Before(t) || !received.IsZero() && !received.Before(t)) {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// verifyNotRevoked checks that the current version is not signed by revoked keys
+func (f *fileSystem) verifyNotRevoked() (err error) {
+	defer catch(&err)
+	root := f.root()
+	if signersRevokedAt(f.revocations, f.owner, root).IsZero() {
+		return nil
+	}
+	return verifyNotRevoked(f.revocations, f.owner, root, f.receivedTime(root, f.received, nil))
+}
+
+// receivedTime returns the earliest of the local time when the root-header was received (if known)
+// and the time attested by trusted notaries
+func (f *fileSystem) receivedTime(h Header, local time.Time, aa []*crypto.Attestation) time.Time {
+	t := local
+	if at := f.attestedTime(h.Hash(), aa); !at.IsZero() && (t.IsZero() || at.Before(t)) {
+		t = at
+	}
+	return t
+}
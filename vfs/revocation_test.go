@@ -0,0 +1,108 @@
+package vfs
+
+import (
+	"github.com/denisskin/dweb/crypto"
+	"github.com/denisskin/dweb/db/memdb"
+	"github.com/denisskin/dweb/vfs/test_data"
+	"testing"
+	"time"
+)
+
+func TestRevocationStore(t *testing.T) {
+	storage := memdb.New()
+	rs, err := OpenRevocationStore(storage)
+	assert(t, err == nil)
+
+	key1 := testPrv.SubKey("key1")
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r1 := tryVal(NewRevocation(key1, key1.PublicKey(), t0.Add(time.Hour)))
+	r2 := tryVal(NewRevocation(testPrv, key1.PublicKey(), t0))
+	assert(t, rs.AddRevocation(r1) == nil)
+	assert(t, rs.AddRevocation(r2) == nil)
+	assert(t, rs.AddRevocation(tryVal(NewRevocation(key1, key1.PublicKey(), t0.Add(2*time.Hour)))) == nil) // later record is ignored
+
+	invalid := r1.Copy()
+	invalid.SetTime(headerRevoked, t0.Add(-time.Hour))
+	assert(t, rs.AddRevocation(invalid) != nil)
+
+	// reopen
+	rs, err = OpenRevocationStore(storage)
+	assert(t, err == nil)
+	rr, err := rs.Revocations(key1.PublicKey())
+	assert(t, err == nil)
+	assert(t, toJSON(rr) == toJSON([]Header{r1, r2}))
+
+	rr, err = rs.Revocations(testPub)
+	assert(t, err == nil)
+	assert(t, len(rr) == 0)
+}
+
+func TestFileSystem_Commit_revokedKey(t *testing.T) {
+	rs := tryVal(OpenRevocationStore(memdb.New()))
+	storage := memdb.New()
+	s := tryVal(OpenVFS(testPub, storage, WithRevocationStore(rs), WithTrustedNotaries(testNotary.PublicKey())))
+	next := testPrv.SubKey("next")
+	t0 := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	commit := tryVal(MakeRotationCommit(s, testPrv, next.PublicKey(), test_data.FS("commit1"), t0))
+	commit.Attestations = []*crypto.Attestation{newTestAttestation(commit.Hash(), t0)}
+	err := s.Commit(commit)
+	assert(t, err == nil)
+
+	// revocation signed by other key is ignored
+	try(rs.AddRevocation(tryVal(NewRevocation(testPrv.SubKey("other"), testPub, t0))))
+	_, err = s.GetCommit(0)
+	assert(t, err == nil)
+
+	// the site key leaked
+	tRevoked := t0.Add(30 * time.Minute)
+	try(rs.AddRevocation(tryVal(NewRevocation(testPrv, testPub, tRevoked))))
+
+	// content attested before the revocation is served
+	_, err = s.GetCommit(0)
+	assert(t, err == nil)
+	_, err = s.FileRangeProof("/readme.txt", 0, 1)
+	assert(t, err == nil)
+
+	// commits signed by the revoked key after the revocation are refused
+	commit = tryVal(MakeCommit(s, testPrv, test_data.FS("commit2"), tRevoked.Add(time.Minute)))
+	err = s.Commit(commit)
+	assert(t, err != nil)
+
+	// backdated commits received after the revocation are refused
+	commit = tryVal(MakeCommit(s, testPrv, test_data.FS("commit2"), tRevoked.Add(-time.Minute)))
+	err = s.Commit(commit)
+	assert(t, err != nil)
+
+	// replica without revocation records accepts the commit, but refuses to serve it after the records are known
+	s2 := tryVal(OpenVFS(testPub, storage))
+	err = s2.Commit(commit)
+	assert(t, err == nil)
+	s3 := tryVal(OpenVFS(testPub, storage, WithRevocationStore(rs)))
+	_, err = s3.GetCommit(0)
+	assert(t, err == ErrRevoked)
+	_, err = s3.OpenAt("/readme.txt", 0)
+	assert(t, err == ErrRevoked)
+	_, err = s3.FileRangeProof("/readme.txt", 0, 1)
+	assert(t, err == ErrRevoked)
+	_, err = s3.FileHeader("/readme.txt")
+	assert(t, err == ErrRevoked)
+	_, err = s3.ReadDir("/")
+	assert(t, err == ErrRevoked)
+	_, err = s3.FileParts("/readme.txt")
+	assert(t, err == ErrRevoked)
+	_, _, err = s3.FilesMerkleMultiProof([]string{"/readme.txt"})
+	assert(t, err == ErrRevoked)
+	_, err = s3.History(0)
+	assert(t, err == ErrRevoked)
+
+	// the next key recovers the site
+	commit = tryVal(MakeRotationCommit(s3, next, nil, test_data.FS("commit2"), time.Now()))
+	err = s3.Commit(commit)
+	assert(t, err == nil)
+	_, err = s3.GetCommit(0)
+	assert(t, err == nil)
+	_, err = s3.History(0)
+	assert(t, err == nil)
+}
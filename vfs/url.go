@@ -0,0 +1,73 @@
+package vfs
+
+import (
+	"errors"
+	"github.com/denisskin/dweb/crypto"
+	"net/url"
+	"strconv"
+)
+
+// URLScheme is the scheme of dweb URLs: dweb://<site-address>/<path>?ver=N
+const URLScheme = "dweb"
+
+var errInvalidURL = errors.New("invalid dweb URL")
+
+// URL refers to a file or directory of the site
+type URL struct {
+	Site crypto.PublicKey // Ed25519 public key of the site (multi-key sites have no address, see crypto.PublicKey.Address)
+	Path string           // file or directory path
+	Ver  int64            // version of the site (0 – the latest version)
+}
+
+// ParseURL parses URL dweb://<site-address>/<path>?ver=N
+func ParseURL(s string) (*URL, error) {
+	u, err := url.Parse(s)
+	if err != nil ||
+		u.Scheme != URLScheme ||
+		u.User != nil ||
+		u.Port() != "" ||
+		u.Opaque != "" ||
+		u.Fragment != "" {
+		return nil, errInvalidURL
+	}
+	res := &URL{
+		Site: crypto.DecodeAddress(u.Hostname()),
+		Path: u.Path,
+	}
+	if res.Site == nil {
+		return nil, errInvalidURL
+	}
+	if res.Path == "" {
+		res.Path = "/"
+	}
+	if !IsValidPath(res.Path) {
+		return nil, errInvalidPath
+	}
+	q := u.Query()
+	for k, vv := range q {
+		if k != "ver" || len(vv) != 1 {
+			return nil, errInvalidURL
+		}
+		if res.Ver, err = strconv.ParseInt(vv[0], 10, 64); err != nil || res.Ver <= 0 {
+			return nil, errInvalidURL
+		}
+	}
+	return res, nil
+}
+
+// String returns URL in the canonical form
+func (u *URL) String() string {
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	s := (&url.URL{
+		Scheme: URLScheme,
+		Host:   u.Site.Address(),
+		Path:   path,
+	}).String()
+	if u.Ver > 0 {
+		s += "?ver=" + strconv.FormatInt(u.Ver, 10)
+	}
+	return s
+}
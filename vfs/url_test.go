@@ -0,0 +1,56 @@
+package vfs
+
+import (
+	"testing"
+)
+
+func TestParseURL(t *testing.T) {
+	addr := testPub.Address()
+
+	for _, c := range []struct {
+		url, path string
+		ver       int64
+	}{
+		{"dweb://" + addr, "/", 0},
+		{"dweb://" + addr + "/", "/", 0},
+		{"dweb://" + addr + "/A/1.txt", "/A/1.txt", 0},
+		{"dweb://" + addr + "/A/?ver=12", "/A/", 12},
+		{"dweb://" + addr + "/hello%20world%3F.txt", "/hello world?.txt", 0},
+	} {
+		u, err := ParseURL(c.url)
+		assert(t, err == nil)
+		assert(t, u.Site.Equal(testPub))
+		assert(t, u.Path == c.path)
+		assert(t, u.Ver == c.ver)
+
+		// round trip
+		u2, err := ParseURL(u.String())
+		assert(t, err == nil)
+		assert(t, toJSON(u2) == toJSON(u))
+	}
+
+	u := &URL{Site: testPub, Path: "/A/1.txt", Ver: 3}
+	assert(t, u.String() == "dweb://"+addr+"/A/1.txt?ver=3")
+}
+
+func TestParseURL_fail(t *testing.T) {
+	addr := testPub.Address()
+
+	for _, s := range []string{
+		"",
+		"http://" + addr + "/",
+		"dweb://" + addr[1:] + "/",
+		"dweb://" + testPub.Encode() + "/",
+		"dweb://" + addr + ":80/",
+		"dweb://user@" + addr + "/",
+		"dweb://" + addr + "/A//1.txt",
+		"dweb://" + addr + "/?ver=abc",
+		"dweb://" + addr + "/?ver=0",
+		"dweb://" + addr + "/?ver=1&ver=2",
+		"dweb://" + addr + "/?v=1",
+		"dweb://" + addr + "/#top",
+	} {
+		_, err := ParseURL(s)
+		assert(t, err != nil)
+	}
+}
@@ -3,6 +3,7 @@ package vfs
 import (
 	"bytes"
 	"errors"
+	"github.com/denisskin/dweb/crypto"
 	"io"
 	"strings"
 )
@@ -16,15 +17,36 @@ type VFS interface {
 	// FileMerkleWitness returns hash and merkle-witness for file or dir-header
 	FileMerkleWitness(path string) (hash, witness []byte, err error)
 
+	// FilesMerkleMultiProof returns headers of files (in tree order) and merkle multi-proof for them
+	FilesMerkleMultiProof(paths []string) (hh []Header, proof *crypto.MerkleMultiProof, err error)
+
 	// FileParts returns hashes of file-parts
 	FileParts(path string) (hashes [][]byte, err error)
 
+	// FilePartWitness returns hash and merkle-witness of the file-part to verify it against file Merkle
+	FilePartWitness(path string, part int) (hash, witness []byte, err error)
+
+	// FileRangeProof returns self-contained proof of file bytes [offset, offset+size)
+	FileRangeProof(path string, offset, size int64) (*RangeProof, error)
+
 	// OpenAt opens file as descriptor
 	OpenAt(path string, offset int64) (io.ReadCloser, error)
 
 	// ReadDir returns headers of directory files
 	ReadDir(path string) ([]Header, error)
 
+	// History returns signed root-headers of the site versions newer than ver (in order of versions)
+	History(ver int64) ([]Header, error)
+
+	// Equivocations returns proofs of the site forks seen by the VFS
+	Equivocations() ([]*Equivocation, error)
+
+	// Attestations returns notary attestations of the root-header with the hash
+	Attestations(hash []byte) ([]*crypto.Attestation, error)
+
+	// AddAttestation verifies and saves the attestation of a trusted notary of the current or one of the previous root-headers
+	AddAttestation(a *crypto.Attestation) error
+
 	// GetCommit makes commit starting from the given version
 	GetCommit(ver int64) (*Commit, error)
 
@@ -35,7 +57,8 @@ type VFS interface {
 }
 
 const (
-	DefaultProtocol     = "0.1"
+	DefaultProtocol     = "0.2"   // 0.2: header hash covers all fields except Signature
+	protocol01          = "0.1"   // 0.1: header hash does not cover the last field before Signature
 	DefaultFilePartSize = 1 << 20 // (1 MiB) – default file part size
 
 	MaxPathNameLength    = 255
@@ -49,9 +72,11 @@ const (
 var (
 	ErrNotFound     = errors.New("not found")
 	ErrTooManyFiles = errors.New("too many files")
+	ErrRevoked      = errors.New("key is revoked")
 
-	errInvalidHeader = errors.New("invalid header")
-	errInvalidPath   = errors.New("invalid header Path")
+	errInvalidHeader    = errors.New("invalid header")
+	errInvalidPath      = errors.New("invalid header Path")
+	errInvalidSignature = errors.New("invalid header Signature")
 )
 
 // IsValidPath says the path is valid
@@ -132,6 +157,37 @@ func dirname(path string) string {
 	return ""
 }
 
+// VerifyFilesMultiProof checks that headers hh belong to the file tree of the root-header
+func VerifyFilesMultiProof(root Header, hh []Header, proof *crypto.MerkleMultiProof) bool {
+	alg := root.HashAlgorithm()
+	if !alg.IsSupported() {
+		return false
+	}
+	leaves := make([][]byte, len(hh))
+	for i, h := range hh {
+		leaves[i] = h.HashBy(alg, root.Protocol())
+	}
+	return alg.VerifyMerkleMultiProof(leaves, root.TreeMerkleRoot(), proof)
+}
+
+// VerifyFilePart checks that data is the i-th part of the file with header h in the tree of the root-header
+func VerifyFilePart(root, h Header, i int, data, witness []byte) bool {
+	alg := root.HashAlgorithm()
+	size, partSize := h.FileSize(), partSizeOf(root, h)
+	if !alg.IsSupported() || size <= 0 || i < 0 || int64(i)*partSize >= size {
+		return false
+	}
+	n := int((size + partSize - 1) / partSize) // count of file parts
+	partLen := size - int64(i)*partSize
+	if partLen > partSize {
+		partLen = partSize
+	}
+	if int64(len(data)) != partLen {
+		return false
+	}
+	return alg.VerifyMerkleWitnessAt(alg.Hash(data), h.FileMerkle(), witness, i, n)
+}
+
 // VersionIsGreater checks that the version of header A is higher than the version of header B
 func VersionIsGreater(a, b Header) bool {
 	if a.Ver() != b.Ver() {